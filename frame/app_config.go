@@ -0,0 +1,511 @@
+package frame
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/derekyu332/goii/helper/logger"
+	"github.com/op/go-logging"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	APP_ENV_PREFIX = "GOII"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	levelType    = reflect.TypeOf(logging.Level(0))
+)
+
+// Keys are matched against exported field names case-insensitively:
+//
+//	file   [MongoInit] Url = "..."        (toml, yaml, yml or json by extension)
+//	env    GOII_MONGOINIT_URL=...
+//	flag   -mongoinit.url=...
+//
+//	named  [SqlInits.report] Uri = "..."  GOII_SQLINITS_REPORT_URI  -sqlinits.report.uri
+//
+// Environment overrides the file and flags override both. Lists are comma separated.
+// Named sections are declared in the file; env and flags only override existing names.
+// Args not naming a config key, e.g. flags of the application itself, are left alone.
+func (this *App) LoadConfig(fileName string, args []string) error {
+	if fileName != "" {
+		values, err := readConfigFile(fileName)
+
+		if err != nil {
+			logger.Error("Read config %v failed %v", fileName, err.Error())
+			return err
+		}
+
+		if err = assignMap(reflect.ValueOf(this).Elem(), values, ""); err != nil {
+			return err
+		}
+
+		logger.Warning("Read config %v success", fileName)
+	}
+
+	if err := overlayEnv(reflect.ValueOf(this).Elem(), APP_ENV_PREFIX); err != nil {
+		return err
+	}
+
+	if err := overlayFlags(reflect.ValueOf(this).Elem(), args); err != nil {
+		return err
+	}
+
+	return this.ValidateConfig()
+}
+
+func (this *App) ValidateConfig() error {
+	var missing []string
+	collectMissing(reflect.ValueOf(this).Elem(), "", &missing)
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required config: %v", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func readConfigFile(fileName string) (map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".toml":
+		err = toml.Unmarshal(content, &values)
+	case ".yaml", ".yml":
+		var raw map[interface{}]interface{}
+
+		if err = yaml.Unmarshal(content, &raw); err == nil {
+			values, _ = normalizeYaml(raw).(map[string]interface{})
+		}
+	case ".json":
+		err = json.Unmarshal(content, &values)
+	default:
+		err = errors.New("unsupported config format " + fileName)
+	}
+
+	return values, err
+}
+
+func normalizeYaml(raw interface{}) interface{} {
+	switch v := raw.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+
+		for key, value := range v {
+			m[fmt.Sprintf("%v", key)] = normalizeYaml(value)
+		}
+
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = normalizeYaml(value)
+		}
+
+		return v
+	}
+
+	return raw
+}
+
+func configurable(field reflect.StructField) bool {
+	if field.PkgPath != "" || field.Tag.Get("config") == "-" {
+		return false
+	}
+
+	switch field.Type.Kind() {
+	case reflect.Map:
+		return isSectionMap(field.Type)
+	case reflect.Func, reflect.Chan, reflect.Interface:
+		return false
+	}
+
+	return true
+}
+
+func isSectionMap(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String &&
+		t.Elem().Kind() == reflect.Ptr && t.Elem().Elem().Kind() == reflect.Struct
+}
+
+func assignSections(fv reflect.Value, values map[string]interface{}, path string) error {
+	if fv.IsNil() {
+		fv.Set(reflect.MakeMap(fv.Type()))
+	}
+
+	for key, raw := range values {
+		sub, ok := raw.(map[string]interface{})
+
+		if !ok {
+			return fmt.Errorf("config %v is not a section", path+key)
+		}
+
+		elem := fv.MapIndex(reflect.ValueOf(key))
+
+		if !elem.IsValid() || elem.IsNil() {
+			elem = reflect.New(fv.Type().Elem().Elem())
+		}
+
+		if err := assignMap(elem.Elem(), sub, path+key+"."); err != nil {
+			return err
+		}
+
+		fv.SetMapIndex(reflect.ValueOf(key), elem)
+	}
+
+	return nil
+}
+
+func assignMap(v reflect.Value, values map[string]interface{}, path string) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if !configurable(field) {
+			continue
+		}
+
+		var raw interface{}
+		found := false
+
+		for key, value := range values {
+			if strings.EqualFold(key, field.Name) {
+				raw, found = value, true
+				break
+			}
+		}
+
+		if !found {
+			continue
+		}
+
+		name := path + field.Name
+		fv := v.Field(i)
+
+		if isSectionMap(fv.Type()) {
+			sub, ok := raw.(map[string]interface{})
+
+			if !ok {
+				return fmt.Errorf("config %v is not a section", name)
+			}
+
+			if err := assignSections(fv, sub, name+"."); err != nil {
+				return err
+			}
+		} else if sub, ok := raw.(map[string]interface{}); ok {
+			target, ok := structTarget(fv)
+
+			if !ok {
+				return fmt.Errorf("config %v is not a section", name)
+			}
+
+			if err := assignMap(target, sub, name+"."); err != nil {
+				return err
+			}
+		} else if err := assignValue(fv, raw); err != nil {
+			return fmt.Errorf("config %v: %v", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func structTarget(fv reflect.Value) (reflect.Value, bool) {
+	if fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+
+		return fv.Elem(), true
+	}
+
+	if fv.Kind() == reflect.Struct {
+		return fv, true
+	}
+
+	return reflect.Value{}, false
+}
+
+func assignValue(fv reflect.Value, raw interface{}) error {
+	if s, ok := raw.(string); ok {
+		return assignString(fv, s)
+	}
+
+	if fv.Kind() == reflect.Slice {
+		items, ok := raw.([]interface{})
+
+		if !ok {
+			return fmt.Errorf("expect list, got %T", raw)
+		}
+
+		slice := reflect.MakeSlice(fv.Type(), len(items), len(items))
+
+		for i, item := range items {
+			if err := assignValue(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+
+		fv.Set(slice)
+
+		return nil
+	}
+
+	// JSON numbers are float64, %v would write large ones with an exponent.
+	if f, ok := raw.(float64); ok {
+		return assignString(fv, strconv.FormatFloat(f, 'f', -1, 64))
+	}
+
+	return assignString(fv, fmt.Sprintf("%v", raw))
+}
+
+func assignString(fv reflect.Value, s string) error {
+	s = strings.TrimSpace(s)
+
+	switch {
+	case fv.Type() == durationType:
+		if d, err := time.ParseDuration(s); err == nil {
+			fv.SetInt(int64(d))
+			return nil
+		}
+	case fv.Type() == levelType:
+		if level, err := logging.LogLevel(s); err == nil {
+			fv.SetInt(int64(level))
+			return nil
+		}
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+
+		if err != nil {
+			return err
+		}
+
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+
+		if err != nil {
+			return err
+		}
+
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+
+		if err != nil {
+			return err
+		}
+
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+
+		if err != nil {
+			return err
+		}
+
+		fv.SetFloat(f)
+	case reflect.Slice:
+		parts := strings.Split(s, ",")
+		slice := reflect.MakeSlice(fv.Type(), 0, len(parts))
+
+		for _, part := range parts {
+			if strings.TrimSpace(part) == "" {
+				continue
+			}
+
+			item := reflect.New(fv.Type().Elem()).Elem()
+
+			if err := assignString(item, part); err != nil {
+				return err
+			}
+
+			slice = reflect.Append(slice, item)
+		}
+
+		fv.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %v", fv.Type())
+	}
+
+	return nil
+}
+
+func walkLeaves(t reflect.Type, getStruct func() reflect.Value, path []string, visit func(path []string, get func() reflect.Value)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if !configurable(field) {
+			continue
+		}
+
+		index := i
+		fieldPath := append(append([]string{}, path...), field.Name)
+		getField := func() reflect.Value {
+			return getStruct().Field(index)
+		}
+
+		if isSectionMap(field.Type) {
+			sections := getField()
+			keys := sections.MapKeys()
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+			for _, key := range keys {
+				mapKey := key
+				walkLeaves(field.Type.Elem().Elem(), func() reflect.Value {
+					return getField().MapIndex(mapKey).Elem()
+				}, append(append([]string{}, fieldPath...), key.String()), visit)
+			}
+		} else if field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct {
+			walkLeaves(field.Type.Elem(), func() reflect.Value {
+				target, _ := structTarget(getField())
+				return target
+			}, fieldPath, visit)
+		} else if field.Type.Kind() == reflect.Struct {
+			walkLeaves(field.Type, getField, fieldPath, visit)
+		} else {
+			visit(fieldPath, getField)
+		}
+	}
+}
+
+func overlayEnv(v reflect.Value, prefix string) error {
+	var err error
+
+	walkLeaves(v.Type(), func() reflect.Value { return v }, nil, func(path []string, get func() reflect.Value) {
+		if err != nil {
+			return
+		}
+
+		key := strings.ToUpper(prefix + "_" + strings.Join(path, "_"))
+
+		if value, ok := os.LookupEnv(key); ok {
+			if e := assignString(get(), value); e != nil {
+				err = fmt.Errorf("env %v: %v", key, e.Error())
+			}
+		}
+	})
+
+	return err
+}
+
+// Accepts -name=value, --name=value and -name value, stopping at "--". A bare bool flag
+// is true, like with the flag package.
+func overlayFlags(v reflect.Value, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	setters := make(map[string]func() reflect.Value)
+
+	walkLeaves(v.Type(), func() reflect.Value { return v }, nil, func(path []string, get func() reflect.Value) {
+		setters[strings.ToLower(strings.Join(path, "."))] = get
+	})
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			break
+		}
+
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		value, has_value := "", false
+
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value, has_value = name[:j], name[j+1:], true
+		}
+
+		setter, ok := setters[strings.ToLower(name)]
+
+		if !ok {
+			continue
+		}
+
+		fv := setter()
+
+		if !has_value && fv.Kind() == reflect.Bool {
+			value = "true"
+		} else if !has_value {
+			if i+1 >= len(args) {
+				return fmt.Errorf("flag %v: missing value", name)
+			}
+
+			i++
+			value = args[i]
+		}
+
+		if err := assignString(fv, value); err != nil {
+			return fmt.Errorf("flag %v: %v", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func collectMissing(v reflect.Value, path string, missing *[]string) {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if !configurable(field) {
+			continue
+		}
+
+		fv := v.Field(i)
+		name := path + field.Name
+
+		if isSectionMap(fv.Type()) {
+			keys := fv.MapKeys()
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+			for _, key := range keys {
+				if elem := fv.MapIndex(key); !elem.IsNil() {
+					collectMissing(elem.Elem(), name+"."+key.String()+".", missing)
+				}
+			}
+		} else if fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct {
+			if !fv.IsNil() {
+				collectMissing(fv.Elem(), name+".", missing)
+			}
+		} else if field.Tag.Get("required") == "true" && isZeroValue(fv) {
+			*missing = append(*missing, name)
+		}
+	}
+}
+
+func isZeroValue(fv reflect.Value) bool {
+	switch fv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return fv.Len() == 0
+	}
+
+	return reflect.DeepEqual(fv.Interface(), reflect.Zero(fv.Type()).Interface())
+}
@@ -6,56 +6,58 @@ import (
 	"github.com/derekyu332/goii/frame/ether"
 	"github.com/derekyu332/goii/frame/i18n"
 	"github.com/derekyu332/goii/frame/kafka"
-	"github.com/derekyu332/goii/frame/mongo"
-	"github.com/derekyu332/goii/frame/rabbit"
-	"github.com/derekyu332/goii/frame/redis"
-	"github.com/derekyu332/goii/frame/sql"
 	"github.com/derekyu332/goii/helper/logger"
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-contrib/size"
 	"github.com/gin-gonic/gin"
-	"github.com/globalsign/mgo"
 	"github.com/op/go-logging"
 	"go/build"
 	"google.golang.org/grpc"
-	"log"
+	"google.golang.org/grpc/health"
 	"math/rand"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 	"github.com/derekyu332/goii/frame/middlewares"
 	"github.com/derekyu332/goii/frame/worker"
+	"github.com/derekyu332/goii/frame/cache"
 	)
 
 type SqlConfig struct {
-	Driver       string
-	Uri          string
+	Driver       string `required:"true"`
+	Uri          string `required:"true"`
+	Replicas     []string
+	Policy       string
+	Weights      []int
 	MaxIdleConns int
+	Audit        bool
 }
 
 type MongoConfig struct {
-	Url    string
-	DbName string
+	Url    string `required:"true"`
+	DbName string `required:"true"`
+	Driver string
 }
 
 type RedisConfig struct {
-	Url      string
+	Url      string `required:"true"`
 	Password string
 }
 
 type WebServerConfig struct {
-	Address string
+	Address string `required:"true"`
 }
 
 type RpcServiceConfig struct {
-	Address string
+	Address string `required:"true"`
 }
 
 type RabbitConfig struct {
-	AmqpURI        string
+	AmqpURI        string `required:"true"`
 	InitCap        int
 	MaxCap         int
 	OpenRpc        bool
@@ -67,9 +69,9 @@ type RabbitConfig struct {
 }
 
 type KafkaConfig struct {
-	Topic            []string
-	GroupId          string
-	BootstrapServers string
+	Topic            []string `required:"true"`
+	GroupId          string   `required:"true"`
+	BootstrapServers string   `required:"true"`
 	SecurityProtocol string
 	SaslMechanism    string
 	SaslUsername     string
@@ -77,13 +79,13 @@ type KafkaConfig struct {
 }
 
 type WorkerPoolConfig struct {
-	Url       string
+	Url       string `required:"true"`
 	Password  string
 	Concurrency int
 }
 
 type EtherConfig struct {
-	DialUrl         string
+	DialUrl         string `required:"true"`
 	FilterAddresses []string
 }
 
@@ -91,9 +93,15 @@ type App struct {
 	WebInit       *WebServerConfig
 	ServiceInit   *RpcServiceConfig
 	SqlInit       *SqlConfig
+	SqlInits      map[string]*SqlConfig
 	MongoInit     *MongoConfig
+	MongoInits    map[string]*MongoConfig
 	RedisInit     *RedisConfig
+	RedisInits    map[string]*RedisConfig
+	CacheBus      string
+	CacheInit     *cache.Config
 	RabbitInit    *RabbitConfig
+	RabbitInits   map[string]*RabbitConfig
 	KafkaInit     *KafkaConfig
 	EtherInit     *EtherConfig
 	WorkerInit    *WorkerPoolConfig
@@ -103,10 +111,28 @@ type App struct {
 	ServiceName   string
 	OpenProfile   bool
 	OpenPrometheus bool
+	OpenHealth    bool
+	HealthTimeout time.Duration
+	RetryInit     *RetryConfig
+	OptionalSubsystems []string
+	ShutdownTimeout time.Duration
 	Components    map[string]base.IComponent
 	engine        *gin.Engine
 	module        base.IModule
 	server        *grpc.Server
+	httpServer    *http.Server
+	subscriber    *ether.EtherSubscriber
+	componentOrder   []string
+	componentStarted []string
+	shutdownHooks []shutdownEntry
+	shutdownLock  sync.Mutex
+	shutdownOnce  sync.Once
+	healthChecks  []healthEntry
+	healthLock    sync.Mutex
+	healthServer  *health.Server
+	healthDone    chan bool
+	ready         int32
+	degraded      []string
 }
 
 var (
@@ -121,15 +147,23 @@ func Application() *App {
 func (this *App) PrepareToRun() error {
 	rand.Seed(time.Now().UnixNano())
 	gApp = this
+
+	if err := this.ValidateConfig(); err != nil {
+		return &StartupError{Subsystem: SUBSYSTEM_CONFIG, Err: err}
+	}
+
 	this.engine = gin.New()
 	this.engine.Use(gin.Recovery(), limits.RequestSizeLimiter(20<<20))
 
-	if this.SoPath == "" {
-		this.SoPath = build.Default.GOPATH + "/src/github.com/derekyu332/goii/plugins/"
+	if err := this.startupStep(SUBSYSTEM_MODULE, false, func() error {
+		var err error
+		this.module, err = loadModule(this.SoPath)
+
+		return err
+	}); err != nil {
+		return err
 	}
 
-	m := base.PluginAllocator(this.SoPath, "module.so")
-	this.module, _ = m.(base.IModule)
 	this.module.SetEngine(this.engine)
 
 	if this.OpenProfile {
@@ -141,43 +175,45 @@ func (this *App) PrepareToRun() error {
 		p.Use(this.engine)
 	}
 
+	if this.OpenHealth {
+		this.registerHealthRoutes()
+	}
+
 	logger.SetLevel((int)(this.LogLevel))
 
-	if this.SqlInit != nil {
-		sql.InitEngine(this.SqlInit.Driver, this.SqlInit.Uri, this.SqlInit.MaxIdleConns)
+	if this.CacheInit != nil {
+		cache.InitLocalCache(this.CacheInit)
 	}
 
-	if this.MongoInit != nil {
-		mongoConfig, err := mgo.ParseURL(this.MongoInit.Url)
-
-		if err != nil {
-			panic(err)
+	if len(this.sqlConfigs()) > 0 {
+		if err := this.startupStep(SUBSYSTEM_SQL, true, this.initSql); err != nil {
+			return err
 		}
+	}
 
-		mongoConfig.PoolTimeout = 3000 * time.Millisecond
-		mongoConfig.ReadTimeout = 10000 * time.Millisecond
-		mongoConfig.WriteTimeout = 5000 * time.Millisecond
-		mongoConfig.MaxIdleTimeMS = 60000
-		mongoConfig.Safe = mgo.Safe{
-			W:        1,
-			WTimeout: 5000,
+	if len(this.mongoConfigs()) > 0 {
+		if err := this.startupStep(SUBSYSTEM_MONGO, true, this.initMongo); err != nil {
+			return err
 		}
-		mongo.InitConnection(mongoConfig, this.MongoInit.DbName)
 	}
 
 	if this.MessageSource != nil {
-		i18n.InitBundle(this.MessageSource)
+		if err := this.startupStep(SUBSYSTEM_I18N, false, func() error {
+			return i18n.InitBundle(this.MessageSource)
+		}); err != nil {
+			return err
+		}
 	}
 
-	if this.RedisInit != nil {
-		redis.InitConnection(this.RedisInit.Url, this.RedisInit.Password)
+	if len(this.redisConfigs()) > 0 {
+		if err := this.startupStep(SUBSYSTEM_REDIS, true, this.initRedis); err != nil {
+			return err
+		}
 	}
 
-	if this.RabbitInit != nil {
-		rabbit.InitProducer(this.RabbitInit.AmqpURI, this.RabbitInit.InitCap, this.RabbitInit.MaxCap, this.RabbitInit.InitCap)
-
-		if this.RabbitInit.OpenRpc {
-			rabbit.InitConsumer(this.RabbitInit.AmqpURI, this.RabbitInit.RpcQueue, this.RabbitInit.RpcRoutineKey)
+	if len(this.rabbitConfigs()) > 0 {
+		if err := this.startupStep(SUBSYSTEM_RABBIT, true, this.initRabbit); err != nil {
+			return err
 		}
 
 		/*if this.RabbitInit.OpenWorker {
@@ -187,35 +223,58 @@ func (this *App) PrepareToRun() error {
 	}
 
 	if this.WorkerInit != nil {
-		worker.InitPool(this.WorkerInit.Url, this.WorkerInit.Password, this.WorkerInit.Concurrency, this.ServiceName)
+		if err := this.startupStep(SUBSYSTEM_WORKER, true, func() error {
+			return worker.InitPool(this.WorkerInit.Url, this.WorkerInit.Password, this.WorkerInit.Concurrency,
+				this.ServiceName)
+		}); err != nil {
+			return err
+		}
 	}
 
 	if this.KafkaInit != nil {
-		kafka.InitWorker(this.KafkaInit.Topic, this.KafkaInit.GroupId, this.KafkaInit.BootstrapServers,
-			this.KafkaInit.SecurityProtocol, this.KafkaInit.SaslMechanism, this.KafkaInit.SaslUsername,
-			this.KafkaInit.SaslPassword, this.module.RunPoll())
+		if err := this.startupStep(SUBSYSTEM_KAFKA, true, func() error {
+			return kafka.InitWorker(this.KafkaInit.Topic, this.KafkaInit.GroupId, this.KafkaInit.BootstrapServers,
+				this.KafkaInit.SecurityProtocol, this.KafkaInit.SaslMechanism, this.KafkaInit.SaslUsername,
+				this.KafkaInit.SaslPassword, this.module.RunPoll())
+		}); err != nil {
+			return err
+		}
 	}
 
 	if this.EtherInit != nil {
-		ether.NewSubscriber(this.EtherInit.DialUrl, this.EtherInit.FilterAddresses, this.module.RunEther())
+		if err := this.startupStep(SUBSYSTEM_ETHER, true, func() error {
+			var err error
+			this.subscriber, err = ether.NewSubscriber(this.EtherInit.DialUrl, this.EtherInit.FilterAddresses,
+				this.module.RunEther())
+
+			return err
+		}); err != nil {
+			return err
+		}
 	}
 
 	if this.Components != nil {
-		for _, com := range this.Components {
-			if err := com.Initialize(); err != nil {
-				panic(err)
-			}
+		if err := this.startupStep(SUBSYSTEM_COMPONENT, false, this.initComponents); err != nil {
+			return err
 		}
 	}
 
 	if this.ServiceInit != nil {
 		customInterceptor := this.module.RunService()
 		this.server = grpc.NewServer(grpc.UnaryInterceptor(customInterceptor))
+
+		if this.OpenHealth {
+			this.registerHealthService()
+		}
 	}
 
 	return nil
 }
 
+func defaultSoPath() string {
+	return build.Default.GOPATH + "/src/github.com/derekyu332/goii/plugins/"
+}
+
 func (this *App) GetServer() *grpc.Server {
 	return this.server
 }
@@ -224,6 +283,10 @@ func (this *App) GetEngine() *gin.Engine {
 	return this.engine
 }
 
+func (this *App) GetModule() base.IModule {
+	return this.module
+}
+
 func (this *App) GetComponent(comId string) base.IComponent {
 	com, ok := this.Components[comId]
 
@@ -296,20 +359,25 @@ func ReloadAllConfigs() {
 	}
 }
 
-func (this *App) Run() {
-	var http_server *http.Server
+// Returns the error of a component failing to start, the components started before it
+// are stopped again. Otherwise Run serves until the application is shut down by a signal.
+func (this *App) Run() error {
+	if err := this.startComponents(); err != nil {
+		logger.Error("Application Start failed %v", err.Error())
+		return err
+	}
 
 	if this.WebInit != nil {
-		http_server = &http.Server{Addr: this.WebInit.Address, Handler: this.engine}
+		this.httpServer = &http.Server{Addr: this.WebInit.Address, Handler: this.engine}
 
 		go func() {
-			if err := http_server.ListenAndServe(); err != nil {
+			if err := this.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 				panic(err)
 			}
 		}()
 	}
 
-	if this.WorkerInit != nil {
+	if this.WorkerInit != nil && !this.IsDegraded(SUBSYSTEM_WORKER) {
 		worker.Run(this.ServiceName, this.module)
 	}
 
@@ -327,9 +395,16 @@ func (this *App) Run() {
 		}()
 	}
 
+	this.setReady(true)
+
+	if this.healthServer != nil {
+		this.healthDone = make(chan bool)
+		go this.watchHealth(this.healthDone)
+	}
+
 	logger.Warning("Application Start Success")
-	ch := make(chan os.Signal)
-	signal.Notify(ch, syscall.SIGUSR1, syscall.SIGUSR2)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGTERM, syscall.SIGINT)
 
 	for s := range ch {
 		switch s {
@@ -337,26 +412,22 @@ func (this *App) Run() {
 			{
 				ReloadAllConfigs()
 			}
-		case syscall.SIGUSR2:
+		case syscall.SIGUSR2, syscall.SIGTERM, syscall.SIGINT:
 			{
-				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-				defer cancel()
-
-				if http_server != nil {
-					if err := http_server.Shutdown(ctx); err != nil {
-						log.Fatal("Server Shutdown:", err)
-					}
-				}
-
-				logger.Warning("Application Shutdown Success")
-
-				if this.WorkerInit != nil {
-					worker.StopPool()
-					logger.Warning("Worker Shutdown Success")
+				logger.Warning("Receive Signal %v", s)
+				signal.Stop(ch)
+				ctx, cancel := context.WithTimeout(context.Background(), this.shutdownTimeout())
+				err := this.Shutdown(ctx)
+				cancel()
+
+				if err != nil {
+					os.Exit(1)
 				}
 
 				os.Exit(0)
 			}
 		}
 	}
+
+	return nil
 }
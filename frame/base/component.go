@@ -1,5 +1,23 @@
 package base
 
+import "context"
+
 type IComponent interface {
 	Initialize() error
 }
+
+type IDependent interface {
+	Dependencies() []string
+}
+
+type IStartable interface {
+	Start() error
+}
+
+type IStoppable interface {
+	Stop(ctx context.Context) error
+}
+
+type IHealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
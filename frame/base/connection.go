@@ -1,5 +1,23 @@
 package base
 
+const (
+	DEFAULT_CONNECTION = "default"
+)
+
 type IConnection interface {
 	FindOne(IActiveRecord)
 }
+
+type IConnectionName interface {
+	ConnectionName() string
+}
+
+func ConnectionOf(record interface{}) string {
+	if named, ok := record.(IConnectionName); ok {
+		if name := named.ConnectionName(); name != "" {
+			return name
+		}
+	}
+
+	return DEFAULT_CONNECTION
+}
@@ -0,0 +1,111 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HEADER_TRACE_ID = "X-Trace-Id"
+)
+
+// Request context of c carrying the request id, trace id and identity, so that cancellation of
+// the http request reaches the db calls made on behalf of it.
+func RequestContext(c *gin.Context) context.Context {
+	if c == nil {
+		return context.Background()
+	}
+
+	ctx := context.Background()
+
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	if request_id, ok := c.Get(KEY_REQUEST_ID); ok {
+		ctx = context.WithValue(ctx, KEY_REQUEST_ID, request_id)
+	}
+
+	if trace_id := c.GetString(KEY_TRACE_ID); trace_id != "" {
+		ctx = context.WithValue(ctx, KEY_TRACE_ID, trace_id)
+	} else if c.Request != nil && c.Request.Header.Get(HEADER_TRACE_ID) != "" {
+		ctx = context.WithValue(ctx, KEY_TRACE_ID, c.Request.Header.Get(HEADER_TRACE_ID))
+	}
+
+	if identity := c.GetString(KEY_IDENTITY); identity != "" {
+		ctx = context.WithValue(ctx, KEY_IDENTITY, identity)
+	}
+
+	return ctx
+}
+
+func RequestIDOf(ctx context.Context) int64 {
+	if ctx == nil {
+		return 0
+	}
+
+	request_id, _ := ctx.Value(KEY_REQUEST_ID).(int64)
+
+	return request_id
+}
+
+func TraceIDOf(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	trace_id, _ := ctx.Value(KEY_TRACE_ID).(string)
+
+	return trace_id
+}
+
+// Id of the authenticated user the request is made for.
+func IdentityOf(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	identity, _ := ctx.Value(KEY_IDENTITY).(string)
+
+	return identity
+}
+
+// A *gin.Context is replaced by its RequestContext. RequestID and Operator are taken from
+// ctx unless they are already set.
+func (this *Model) BindContext(ctx context.Context) {
+	if c, ok := ctx.(*gin.Context); ok {
+		ctx = RequestContext(c)
+	}
+
+	this.ctx = ctx
+
+	if this.RequestID == 0 {
+		this.RequestID = RequestIDOf(ctx)
+	}
+
+	if this.Operator == "" {
+		this.Operator = IdentityOf(ctx)
+	}
+}
+
+func (this *Model) Context() context.Context {
+	if this.ctx == nil {
+		return context.Background()
+	}
+
+	return this.ctx
+}
+
+func (this *Model) TraceID() string {
+	return TraceIDOf(this.ctx)
+}
+
+// Request id of log lines, followed by the trace id when there is one.
+func (this *Model) LogID() string {
+	if trace_id := this.TraceID(); trace_id != "" {
+		return fmt.Sprintf("%v/%v", this.RequestID, trace_id)
+	}
+
+	return fmt.Sprintf("%v", this.RequestID)
+}
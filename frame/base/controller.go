@@ -1,6 +1,7 @@
 package base
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/derekyu332/goii/frame/formatters"
@@ -75,6 +76,19 @@ func (this *WebController) GetContext() *gin.Context {
 	return this.Context
 }
 
+// Request context of the action, carrying its request id, trace id and the identity set
+// by authentication.
+func (this *WebController) RequestContext() context.Context {
+	return RequestContext(this.Context)
+}
+
+// Binds models to the request context, so their logs and db calls follow the action.
+func (this *WebController) BindModels(models ...IContextBinder) {
+	for _, model := range models {
+		model.BindContext(this.RequestContext())
+	}
+}
+
 func (this *WebController) TitleRet() string {
 	return "ret"
 }
@@ -0,0 +1,102 @@
+package base
+
+import (
+	"reflect"
+)
+
+type IBeforeValidate interface {
+	BeforeValidate() error
+}
+
+type IBeforeSave interface {
+	BeforeSave(insert bool) error
+}
+
+type IAfterSave interface {
+	AfterSave(insert bool) error
+}
+
+type IBeforeDelete interface {
+	BeforeDelete() error
+}
+
+type IAfterDelete interface {
+	AfterDelete() error
+}
+
+type IAfterFind interface {
+	AfterFind() error
+}
+
+func RunBeforeValidate(record interface{}) error {
+	if hook, ok := record.(IBeforeValidate); ok {
+		return hook.BeforeValidate()
+	}
+
+	return nil
+}
+
+func RunBeforeSave(record interface{}, insert bool) error {
+	if hook, ok := record.(IBeforeSave); ok {
+		return hook.BeforeSave(insert)
+	}
+
+	return nil
+}
+
+func RunAfterSave(record interface{}, insert bool) error {
+	if hook, ok := record.(IAfterSave); ok {
+		return hook.AfterSave(insert)
+	}
+
+	return nil
+}
+
+func RunBeforeDelete(record interface{}) error {
+	if hook, ok := record.(IBeforeDelete); ok {
+		return hook.BeforeDelete()
+	}
+
+	return nil
+}
+
+func RunAfterDelete(record interface{}) error {
+	if hook, ok := record.(IAfterDelete); ok {
+		return hook.AfterDelete()
+	}
+
+	return nil
+}
+
+func RunAfterFind(record interface{}) error {
+	if hook, ok := record.(IAfterFind); ok {
+		return hook.AfterFind()
+	}
+
+	return nil
+}
+
+// Result is a pointer to a slice of records or record pointers, as passed to FindAll.
+func RunAfterFindAll(result interface{}) error {
+	v := reflect.ValueOf(result)
+
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return nil
+	}
+
+	v = v.Elem()
+
+	for i := 0; i < v.Len(); i++ {
+		item := v.Index(i)
+
+		if item.Kind() != reflect.Ptr && item.CanAddr() {
+			item = item.Addr()
+		}
+
+		if err := RunAfterFind(item.Interface()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package base
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type IMigration interface {
+	MigrationVersion() int64
+	MigrationName() string
+}
+
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrations of each connection in version order. Applied maps the versions recorded by
+// a store to when they were applied.
+type MigrationRegistry[T IMigration] struct {
+	lock       sync.Mutex
+	migrations map[string][]T
+}
+
+// Versions are unique per connection, a duplicate rejects the whole call.
+func (this *MigrationRegistry[T]) Register(name string, migrations ...T) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.migrations == nil {
+		this.migrations = make(map[string][]T)
+	}
+
+	versions := make(map[int64]bool)
+
+	for _, migration := range this.migrations[name] {
+		versions[migration.MigrationVersion()] = true
+	}
+
+	for _, migration := range migrations {
+		if versions[migration.MigrationVersion()] {
+			return fmt.Errorf("Migration %v of %v registered twice", migration.MigrationVersion(), name)
+		}
+
+		versions[migration.MigrationVersion()] = true
+	}
+
+	named := append(this.migrations[name], migrations...)
+	sort.Slice(named, func(i, j int) bool {
+		return named[i].MigrationVersion() < named[j].MigrationVersion()
+	})
+	this.migrations[name] = named
+
+	return nil
+}
+
+func (this *MigrationRegistry[T]) Named(name string) []T {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return append([]T(nil), this.migrations[name]...)
+}
+
+func (this *MigrationRegistry[T]) Statuses(name string, applied map[int64]time.Time) []MigrationStatus {
+	var statuses []MigrationStatus
+
+	for _, migration := range this.Named(name) {
+		status := MigrationStatus{Version: migration.MigrationVersion(), Name: migration.MigrationName()}
+		status.AppliedAt, status.Applied = applied[status.Version]
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// Oldest first, steps <= 0 takes every pending migration.
+func (this *MigrationRegistry[T]) Pending(name string, applied map[int64]time.Time, steps int) []T {
+	var pending []T
+
+	for _, migration := range this.Named(name) {
+		if steps > 0 && len(pending) >= steps {
+			break
+		}
+
+		if _, ok := applied[migration.MigrationVersion()]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending
+}
+
+// Latest first, steps <= 0 takes the latest applied migration only.
+func (this *MigrationRegistry[T]) Applied(name string, applied map[int64]time.Time, steps int) []T {
+	if steps <= 0 {
+		steps = 1
+	}
+
+	var latest []T
+	migrations := this.Named(name)
+
+	for i := len(migrations) - 1; i >= 0 && len(latest) < steps; i-- {
+		if _, ok := applied[migrations[i].MigrationVersion()]; ok {
+			latest = append(latest, migrations[i])
+		}
+	}
+
+	return latest
+}
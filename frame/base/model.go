@@ -1,6 +1,7 @@
 package base
 
 import (
+	"context"
 	"github.com/derekyu332/goii/helper/extend"
 	"reflect"
 	"strings"
@@ -23,6 +24,10 @@ type IFieldMethod interface {
 	Attr(string) interface{}
 }
 
+type IContextBinder interface {
+	BindContext(context.Context)
+}
+
 type IRecordContext interface {
 	GetContext() interface{}
 	SetContext(interface{})
@@ -32,6 +37,8 @@ type Model struct {
 	Data          IActiveRecord //interface{}
 	Exists        bool
 	RequestID     int64
+	Operator      string
+	ctx           context.Context
 	scenarioName  string
 	scenariosMaps map[string][]string
 }
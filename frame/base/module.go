@@ -11,6 +11,8 @@ const KEY_REQUEST_ID = "KEY_REQUEST_ID"
 const KEY_ACTION_RET = "KEY_ACTION_RET"
 const KEY_RESPONSE = "KEY_RESPONSE"
 const KEY_IDENTITY = "KEY_IDENTITY"
+const KEY_TRACE_ID = "KEY_TRACE_ID"
+const KEY_SERVICE = "KEY_SERVICE"
 
 type IModule interface {
 	SetEngine(eg *gin.Engine)
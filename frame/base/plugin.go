@@ -1,27 +1,38 @@
 package base
 
 import (
+	"errors"
 	"plugin"
 )
 
-func PluginAllocator(path string, name string) interface{} {
+func LoadPlugin(path string, name string) (interface{}, error) {
 	p, err := plugin.Open(path + name)
 
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	n, err := p.Lookup("New")
 
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	fn, ok := n.(func() interface{})
 
 	if ok == false {
-		panic("New Func type error")
+		return nil, errors.New("New Func type error")
+	}
+
+	return fn(), nil
+}
+
+func PluginAllocator(path string, name string) interface{} {
+	m, err := LoadPlugin(path, name)
+
+	if err != nil {
+		panic(err)
 	}
 
-	return fn()
+	return m
 }
@@ -0,0 +1,327 @@
+package base
+
+import (
+	"fmt"
+	"github.com/derekyu332/goii/frame/i18n"
+	"github.com/derekyu332/goii/helper/extend"
+	"github.com/gin-gonic/gin"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	RULE_REQUIRED = "required"
+	RULE_RANGE    = "range"
+	RULE_LENGTH   = "length"
+	RULE_MATCH    = "match"
+	RULE_CUSTOM   = "custom"
+)
+
+var (
+	gRulePatterns sync.Map
+)
+
+// Fields are attribute names as used by Fields() and the scenarios. Range is "min~max" for
+// RULE_RANGE and RULE_LENGTH, either side may be left empty. Message overrides the i18n
+// message id, which defaults to "validation.<type>".
+type Rule struct {
+	Fields    []string
+	Type      string
+	Scenarios []string
+	Range     string
+	Pattern   string
+	Func      func(value interface{}) error
+	Message   string
+}
+
+type IRuleRecord interface {
+	Rules() []Rule
+}
+
+type ValidationError struct {
+	Field     string
+	Rule      string
+	MessageID string
+	Params    map[string]interface{}
+	Message   string
+}
+
+func (this *ValidationError) Error() string {
+	return this.Message
+}
+
+// Localized message of the error, falls back to the english message when the
+// bundle has no translation.
+func (this *ValidationError) Translate(c *gin.Context) string {
+	if msg := i18n.T(c, this.MessageID, this.Params); msg != "" {
+		return msg
+	}
+
+	return this.Message
+}
+
+type ValidationErrors map[string][]*ValidationError
+
+func (this ValidationErrors) add(err *ValidationError) {
+	this[err.Field] = append(this[err.Field], err)
+}
+
+func (this ValidationErrors) Fields() []string {
+	fields := make([]string, 0, len(this))
+
+	for field := range this {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+
+	return fields
+}
+
+func (this ValidationErrors) Error() string {
+	var msgs []string
+
+	for _, field := range this.Fields() {
+		for _, err := range this[field] {
+			msgs = append(msgs, err.Message)
+		}
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// First localized message of every field.
+func (this ValidationErrors) Messages(c *gin.Context) map[string]string {
+	msgs := make(map[string]string)
+
+	for field, errs := range this {
+		if len(errs) > 0 {
+			msgs[field] = errs[0].Translate(c)
+		}
+	}
+
+	return msgs
+}
+
+func ValidationHttpError(c *gin.Context, errs ValidationErrors) *HttpError {
+	fields := errs.Fields()
+
+	if len(fields) == 0 || len(errs[fields[0]]) == 0 {
+		return InvalidParaHttpError(c, "")
+	}
+
+	return CustomHttpError(c, ERR_INVALID_PARA, errs[fields[0]][0].Translate(c))
+}
+
+func (this *Model) Scenario() string {
+	if this.scenarioName == "" {
+		return DEFAULT_SCENARIO
+	}
+
+	return this.scenarioName
+}
+
+// Runs the rules of the current scenario against this.Data, returns ValidationErrors
+// keyed by attribute name or nil.
+func (this *Model) Validate() error {
+	record, ok := this.Data.(IRuleRecord)
+
+	if !ok {
+		return nil
+	}
+
+	values := StructToMap(this.Data, "attr")
+	scenario := this.Scenario()
+	errs := make(ValidationErrors)
+
+	for _, rule := range record.Rules() {
+		if len(rule.Scenarios) > 0 && extend.InStringArray(scenario, rule.Scenarios) < 0 {
+			continue
+		}
+
+		for _, field := range rule.Fields {
+			if err := rule.check(field, values[field]); err != nil {
+				errs.add(err)
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+func (this *Rule) check(field string, value interface{}) *ValidationError {
+	params := map[string]interface{}{"Field": field}
+	var msg string
+
+	switch this.Type {
+	case RULE_REQUIRED:
+		{
+			if isEmptyValue(value) {
+				msg = field + " required"
+			}
+		}
+	case RULE_RANGE:
+		{
+			number, ok := toFloat64(value)
+			min, max := splitRange(this.Range)
+			params["Min"], params["Max"] = min, max
+
+			if !ok {
+				msg = field + " is not number"
+			} else if !inRange(number, min, max) {
+				msg = field + " value out of range " + this.Range
+			}
+		}
+	case RULE_LENGTH:
+		{
+			length, ok := lengthOf(value)
+			min, max := splitRange(this.Range)
+			params["Min"], params["Max"] = min, max
+
+			if !ok {
+				msg = field + " has no length"
+			} else if !inRange(float64(length), min, max) {
+				msg = field + " length out of range " + this.Range
+			}
+		}
+	case RULE_MATCH:
+		{
+			params["Pattern"] = this.Pattern
+			str := fmt.Sprintf("%v", value)
+
+			if pattern, err := compilePattern(this.Pattern); err != nil {
+				msg = field + " invalid pattern " + this.Pattern
+			} else if str != "" && !pattern.MatchString(str) {
+				msg = field + " not match " + this.Pattern
+			}
+		}
+	case RULE_CUSTOM:
+		{
+			if this.Func != nil {
+				if err := this.Func(value); err != nil {
+					msg = err.Error()
+				}
+			}
+		}
+	default:
+		{
+			msg = field + " unknown rule " + this.Type
+		}
+	}
+
+	if msg == "" {
+		return nil
+	}
+
+	message_id := this.Message
+
+	if message_id == "" {
+		message_id = "validation." + this.Type
+	}
+
+	return &ValidationError{Field: field, Rule: this.Type, MessageID: message_id, Params: params, Message: msg}
+}
+
+func compilePattern(expr string) (*regexp.Regexp, error) {
+	if pattern, ok := gRulePatterns.Load(expr); ok {
+		return pattern.(*regexp.Regexp), nil
+	}
+
+	pattern, err := regexp.Compile(expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	gRulePatterns.Store(expr, pattern)
+
+	return pattern, nil
+}
+
+func splitRange(rule string) (interface{}, interface{}) {
+	var min, max interface{}
+	range_rule := strings.Split(rule, "~")
+
+	if len(range_rule) != 2 {
+		return nil, nil
+	}
+
+	if v, err := strconv.ParseFloat(range_rule[0], 64); err == nil {
+		min = v
+	}
+
+	if v, err := strconv.ParseFloat(range_rule[1], 64); err == nil {
+		max = v
+	}
+
+	return min, max
+}
+
+func inRange(value float64, min interface{}, max interface{}) bool {
+	if min != nil && value < min.(float64) {
+		return false
+	}
+
+	if max != nil && value > max.(float64) {
+		return false
+	}
+
+	return true
+}
+
+func isEmptyValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(value)
+
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+
+	return v.IsZero()
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		number, err := strconv.ParseFloat(v.String(), 64)
+		return number, err == nil
+	}
+
+	return 0, false
+}
+
+func lengthOf(value interface{}) (int, bool) {
+	v := reflect.ValueOf(value)
+
+	switch v.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(v.String()), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len(), true
+	}
+
+	return 0, false
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/derekyu332/goii/frame/redis"
 	"github.com/derekyu332/goii/helper/logger"
 	"github.com/gin-gonic/gin"
+	redigo "github.com/gomodule/redigo/redis"
 	"strings"
 )
 
@@ -15,9 +16,20 @@ type ActionLock struct {
 	VaryByRoute bool
 	Variations  map[string][]string
 	Expiration  int64
+	Connection  string
 	lockKey     string
 }
 
+func (this *ActionLock) GetPool() *redigo.Pool {
+	if this.Connection != "" {
+		if pool := redis.GetNamedPool(this.Connection); pool != nil {
+			return pool
+		}
+	}
+
+	return this.RedisModel.GetPool()
+}
+
 func (this *ActionLock) BeforeAction(c *gin.Context) error {
 	if this.Expiration < 0 || this.Variations == nil {
 		return nil
@@ -28,8 +28,9 @@ type PageCache struct {
 	cached     bool
 }
 
+// Service calls are never cached, their response is not the map kept in KEY_RESPONSE.
 func (this *PageCache) BeforeAction(c *gin.Context) error {
-	if this.Duration <= 0 || this.Variations == nil {
+	if this.Duration <= 0 || this.Variations == nil || c.GetBool(base.KEY_SERVICE) {
 		return nil
 	} else if this.Dependency != nil && this.Dependency(c) {
 		return nil
@@ -91,7 +92,7 @@ func (this *PageCache) getCacheFile(key string) string {
 }
 
 func (this *PageCache) AfterAction(c *gin.Context) error {
-	if this.Duration <= 0 || this.Variations == nil {
+	if this.Duration <= 0 || this.Variations == nil || c.GetBool(base.KEY_SERVICE) {
 		return nil
 	}
 
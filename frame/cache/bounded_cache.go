@@ -0,0 +1,484 @@
+package cache
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	POLICY_LRU         = "lru"
+	POLICY_TINYLFU     = "tinylfu"
+	DEFAULT_EXPIRATION = 5 * time.Minute
+	CLEANUP_INTERVAL   = 10 * time.Minute
+	DEFAULT_ENTRY_SIZE = 512
+	NO_EXPIRATION      = time.Duration(-1)
+	SKETCH_DEPTH       = 4
+	SKETCH_MIN_WIDTH   = 1024
+	SKETCH_MAX_COUNT   = 15
+)
+
+// Zero MaxEntries or MaxBytes leaves that bound off. Quotas cap the entry count of a
+// TableName. With POLICY_TINYLFU a new key only replaces a victim it is used more often than.
+type Config struct {
+	Policy     string
+	MaxEntries int
+	MaxBytes   int64
+	Expiration time.Duration
+	Quotas     map[string]int
+}
+
+// Values implementing ISizer report their own size, []byte and string count their length,
+// anything else counts DEFAULT_ENTRY_SIZE.
+type ISizer interface {
+	CacheSize() int64
+}
+
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Entries   int
+	Bytes     int64
+}
+
+func (this CacheStats) HitRate() float64 {
+	if this.Hits+this.Misses == 0 {
+		return 0
+	}
+
+	return float64(this.Hits) / float64(this.Hits+this.Misses)
+}
+
+type cacheEntry struct {
+	key      string
+	table    string
+	value    interface{}
+	size     int64
+	expires  int64
+	accessed uint64
+	elem     *list.Element
+}
+
+type BoundedCache struct {
+	config Config
+	lock   sync.Mutex
+	items  map[string]*cacheEntry
+	tables map[string]*list.List
+	stats  map[string]*CacheStats
+	bytes  int64
+	tick   uint64
+	sketch *frequencySketch
+	done   chan bool
+}
+
+func NewBoundedCache(config Config) *BoundedCache {
+	if config.Expiration == 0 {
+		config.Expiration = DEFAULT_EXPIRATION
+	}
+
+	this := &BoundedCache{
+		config: config,
+		items:  make(map[string]*cacheEntry),
+		tables: make(map[string]*list.List),
+		stats:  make(map[string]*CacheStats),
+		done:   make(chan bool),
+	}
+
+	if config.Policy == POLICY_TINYLFU {
+		this.sketch = newFrequencySketch(config.MaxEntries)
+	}
+
+	go this.janitor()
+
+	return this
+}
+
+func (this *BoundedCache) Close() {
+	close(this.done)
+}
+
+func (this *BoundedCache) Get(key string) (interface{}, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.sketch != nil {
+		this.sketch.increment(key)
+	}
+
+	entry, ok := this.items[key]
+
+	if !ok {
+		this.statsOf(tableOf(key)).Misses++
+		return nil, false
+	}
+
+	if entry.expires > 0 && entry.expires < time.Now().UnixNano() {
+		this.remove(entry)
+		this.statsOf(entry.table).Misses++
+		gCacheEvictions.WithLabelValues(entry.table, "expired").Inc()
+
+		return nil, false
+	}
+
+	this.touch(entry)
+	this.statsOf(entry.table).Hits++
+
+	return entry.value, true
+}
+
+// Set takes the table from keys shaped like "id@table", see SetTable.
+func (this *BoundedCache) Set(key string, value interface{}, d time.Duration) {
+	this.SetTable(tableOf(key), key, value, d)
+}
+
+// Zero d uses the configured expiration, NO_EXPIRATION keeps the entry until evicted.
+func (this *BoundedCache) SetTable(table string, key string, value interface{}, d time.Duration) {
+	entry := &cacheEntry{key: key, table: table, value: value, size: sizeOf(value)}
+
+	if d == 0 {
+		d = this.config.Expiration
+	}
+
+	if d > 0 {
+		entry.expires = time.Now().Add(d).UnixNano()
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.sketch != nil {
+		this.sketch.increment(key)
+	}
+
+	if old, ok := this.items[key]; ok {
+		this.remove(old)
+	} else if !this.admit(entry) {
+		return
+	}
+
+	if this.config.MaxBytes > 0 && entry.size > this.config.MaxBytes {
+		return
+	}
+
+	for this.overflow(entry) {
+		if victim := this.oldest(); victim != nil {
+			this.evict(victim, "capacity")
+		} else {
+			break
+		}
+	}
+
+	tableList, ok := this.tables[table]
+
+	if !ok {
+		tableList = list.New()
+		this.tables[table] = tableList
+	}
+
+	this.tick++
+	entry.accessed = this.tick
+	entry.elem = tableList.PushFront(entry)
+	this.items[key] = entry
+	this.bytes += entry.size
+}
+
+func (this *BoundedCache) Delete(key string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if entry, ok := this.items[key]; ok {
+		this.remove(entry)
+	}
+}
+
+func (this *BoundedCache) Flush() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.items = make(map[string]*cacheEntry)
+	this.tables = make(map[string]*list.List)
+	this.bytes = 0
+}
+
+func (this *BoundedCache) ItemCount() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return len(this.items)
+}
+
+func (this *BoundedCache) Stats() map[string]CacheStats {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	stats := make(map[string]CacheStats)
+
+	for table, s := range this.stats {
+		stats[table] = *s
+	}
+
+	for table, tableList := range this.tables {
+		s := stats[table]
+		s.Entries = tableList.Len()
+
+		for elem := tableList.Front(); elem != nil; elem = elem.Next() {
+			s.Bytes += elem.Value.(*cacheEntry).size
+		}
+
+		stats[table] = s
+	}
+
+	return stats
+}
+
+// Makes room under the table quota, false when TinyLFU rejects the entry.
+func (this *BoundedCache) admit(entry *cacheEntry) bool {
+	if quota := this.config.Quotas[entry.table]; quota > 0 {
+		for tableList := this.tables[entry.table]; tableList != nil && tableList.Len() >= quota; {
+			victim := tableList.Back().Value.(*cacheEntry)
+
+			if !this.prefer(entry, victim) {
+				return false
+			}
+
+			this.evict(victim, "quota")
+		}
+	}
+
+	if this.overflow(entry) {
+		if victim := this.oldest(); victim != nil && !this.prefer(entry, victim) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (this *BoundedCache) prefer(entry *cacheEntry, victim *cacheEntry) bool {
+	if this.sketch == nil {
+		return true
+	}
+
+	return this.sketch.estimate(entry.key) > this.sketch.estimate(victim.key)
+}
+
+func (this *BoundedCache) overflow(entry *cacheEntry) bool {
+	if this.config.MaxEntries > 0 && len(this.items)+1 > this.config.MaxEntries {
+		return true
+	}
+
+	return this.config.MaxBytes > 0 && this.bytes+entry.size > this.config.MaxBytes
+}
+
+// Least recently used entry across the tails of every table.
+func (this *BoundedCache) oldest() *cacheEntry {
+	var victim *cacheEntry
+
+	for _, tableList := range this.tables {
+		entry := tableList.Back().Value.(*cacheEntry)
+
+		if victim == nil || entry.accessed < victim.accessed {
+			victim = entry
+		}
+	}
+
+	return victim
+}
+
+func (this *BoundedCache) touch(entry *cacheEntry) {
+	this.tick++
+	entry.accessed = this.tick
+	this.tables[entry.table].MoveToFront(entry.elem)
+}
+
+func (this *BoundedCache) evict(entry *cacheEntry, reason string) {
+	this.remove(entry)
+	this.statsOf(entry.table).Evictions++
+	gCacheEvictions.WithLabelValues(entry.table, reason).Inc()
+}
+
+func (this *BoundedCache) remove(entry *cacheEntry) {
+	tableList := this.tables[entry.table]
+	tableList.Remove(entry.elem)
+
+	if tableList.Len() == 0 {
+		delete(this.tables, entry.table)
+	}
+
+	delete(this.items, entry.key)
+	this.bytes -= entry.size
+}
+
+func (this *BoundedCache) statsOf(table string) *CacheStats {
+	stats, ok := this.stats[table]
+
+	if !ok {
+		stats = &CacheStats{}
+		this.stats[table] = stats
+	}
+
+	return stats
+}
+
+func (this *BoundedCache) janitor() {
+	ticker := time.NewTicker(CLEANUP_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.done:
+			return
+		case <-ticker.C:
+			this.deleteExpired()
+		}
+	}
+}
+
+func (this *BoundedCache) deleteExpired() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	now := time.Now().UnixNano()
+
+	for _, entry := range this.items {
+		if entry.expires > 0 && entry.expires < now {
+			this.remove(entry)
+			gCacheEvictions.WithLabelValues(entry.table, "expired").Inc()
+		}
+	}
+}
+
+func sizeOf(value interface{}) int64 {
+	switch v := value.(type) {
+	case ISizer:
+		return v.CacheSize()
+	case []byte:
+		return int64(len(v))
+	case string:
+		return int64(len(v))
+	}
+
+	return DEFAULT_ENTRY_SIZE
+}
+
+// Count-min sketch of 4 bit counters, halved every 10 * width increments so that old
+// popularity fades.
+type frequencySketch struct {
+	rows      [SKETCH_DEPTH][]uint8
+	mask      uint64
+	additions int
+	limit     int
+}
+
+func newFrequencySketch(capacity int) *frequencySketch {
+	width := SKETCH_MIN_WIDTH
+
+	for width < capacity {
+		width <<= 1
+	}
+
+	this := &frequencySketch{mask: uint64(width - 1), limit: 10 * width}
+
+	for i := range this.rows {
+		this.rows[i] = make([]uint8, width)
+	}
+
+	return this
+}
+
+func (this *frequencySketch) indexes(key string) [SKETCH_DEPTH]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	var indexes [SKETCH_DEPTH]uint64
+
+	for i := range indexes {
+		indexes[i] = (sum + uint64(i)*(sum>>32|1)) & this.mask
+	}
+
+	return indexes
+}
+
+func (this *frequencySketch) increment(key string) {
+	for i, index := range this.indexes(key) {
+		if this.rows[i][index] < SKETCH_MAX_COUNT {
+			this.rows[i][index]++
+		}
+	}
+
+	if this.additions++; this.additions >= this.limit {
+		for i := range this.rows {
+			for j := range this.rows[i] {
+				this.rows[i][j] >>= 1
+			}
+		}
+
+		this.additions /= 2
+	}
+}
+
+func (this *frequencySketch) estimate(key string) uint8 {
+	min := uint8(SKETCH_MAX_COUNT)
+
+	for i, index := range this.indexes(key) {
+		if this.rows[i][index] < min {
+			min = this.rows[i][index]
+		}
+	}
+
+	return min
+}
+
+// Typed access to the local cache.
+func Get[T any](key string) (T, bool) {
+	var zero T
+	data, found := LocalCache().Get(key)
+
+	if !found {
+		return zero, false
+	}
+
+	value, ok := data.(T)
+
+	if !ok {
+		return zero, false
+	}
+
+	return value, true
+}
+
+func Set[T any](table string, key string, value T, d time.Duration) {
+	LocalCache().SetTable(table, key, value, d)
+}
+
+type statsCollector struct {
+	entries *prometheus.Desc
+	bytes   *prometheus.Desc
+	ratio   *prometheus.Desc
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{
+		entries: prometheus.NewDesc("goii_cache_entries", "Entries in the local cache.", []string{"table"}, nil),
+		bytes:   prometheus.NewDesc("goii_cache_bytes", "Approximate bytes in the local cache.", []string{"table"}, nil),
+		ratio:   prometheus.NewDesc("goii_cache_hit_ratio", "Hit ratio of the local cache.", []string{"table"}, nil),
+	}
+}
+
+func (this *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- this.entries
+	ch <- this.bytes
+	ch <- this.ratio
+}
+
+func (this *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	for table, stats := range LocalCache().Stats() {
+		ch <- prometheus.MustNewConstMetric(this.entries, prometheus.GaugeValue, float64(stats.Entries), table)
+		ch <- prometheus.MustNewConstMetric(this.bytes, prometheus.GaugeValue, float64(stats.Bytes), table)
+		ch <- prometheus.MustNewConstMetric(this.ratio, prometheus.GaugeValue, stats.HitRate(), table)
+	}
+}
@@ -0,0 +1,221 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+type sizedValue int64
+
+func (this sizedValue) CacheSize() int64 {
+	return int64(this)
+}
+
+func TestBoundedCacheEviction(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		values  map[string]interface{}
+		steps   []string
+		present []string
+		absent  []string
+	}{
+		{
+			name:    "lru entries",
+			config:  Config{MaxEntries: 2},
+			steps:   []string{"set 1@a", "set 2@a", "get 1@a", "set 3@a"},
+			present: []string{"1@a", "3@a"},
+			absent:  []string{"2@a"},
+		},
+		{
+			name:    "lru across tables",
+			config:  Config{MaxEntries: 2},
+			steps:   []string{"set 1@a", "set 1@b", "get 1@a", "set 2@b"},
+			present: []string{"1@a", "2@b"},
+			absent:  []string{"1@b"},
+		},
+		{
+			name:    "bytes",
+			config:  Config{MaxBytes: 10},
+			values:  map[string]interface{}{"1@a": "abcd", "2@a": "abcd", "3@a": "abcd"},
+			steps:   []string{"set 1@a", "set 2@a", "set 3@a"},
+			present: []string{"2@a", "3@a"},
+			absent:  []string{"1@a"},
+		},
+		{
+			name:    "bytes of sizer",
+			config:  Config{MaxBytes: 10},
+			values:  map[string]interface{}{"1@a": sizedValue(6), "2@a": sizedValue(6)},
+			steps:   []string{"set 1@a", "set 2@a"},
+			present: []string{"2@a"},
+			absent:  []string{"1@a"},
+		},
+		{
+			name:    "larger than max bytes",
+			config:  Config{MaxBytes: 10},
+			values:  map[string]interface{}{"1@a": "abcd", "2@a": []byte("abcdefghijk")},
+			steps:   []string{"set 1@a", "set 2@a"},
+			present: []string{"1@a"},
+			absent:  []string{"2@a"},
+		},
+		{
+			name:    "quota",
+			config:  Config{Quotas: map[string]int{"a": 2}},
+			steps:   []string{"set 1@a", "set 2@a", "set 1@b", "get 1@a", "set 3@a"},
+			present: []string{"1@a", "3@a", "1@b"},
+			absent:  []string{"2@a"},
+		},
+		{
+			name:    "replace within quota",
+			config:  Config{Quotas: map[string]int{"a": 1}},
+			steps:   []string{"set 1@a", "set 1@a"},
+			present: []string{"1@a"},
+		},
+		{
+			name:    "tinylfu rejects cold key",
+			config:  Config{Policy: POLICY_TINYLFU, MaxEntries: 2},
+			steps:   []string{"set 1@a", "set 2@a", "get 1@a", "get 2@a", "set 3@a"},
+			present: []string{"1@a", "2@a"},
+			absent:  []string{"3@a"},
+		},
+		{
+			name:   "tinylfu admits hot key",
+			config: Config{Policy: POLICY_TINYLFU, MaxEntries: 2},
+			steps: []string{"set 1@a", "set 2@a", "get 1@a", "get 2@a",
+				"get 3@a", "get 3@a", "get 3@a", "set 3@a"},
+			present: []string{"2@a", "3@a"},
+			absent:  []string{"1@a"},
+		},
+		{
+			name:   "tinylfu quota",
+			config: Config{Policy: POLICY_TINYLFU, Quotas: map[string]int{"a": 1}},
+			steps: []string{"set 1@a", "get 1@a", "set 2@a",
+				"get 3@a", "get 3@a", "get 3@a", "set 3@a"},
+			present: []string{"3@a"},
+			absent:  []string{"1@a", "2@a"},
+		},
+	}
+
+	for _, test := range tests {
+		cache := NewBoundedCache(test.config)
+
+		for _, step := range test.steps {
+			key := step[4:]
+
+			if step[:3] == "get" {
+				cache.Get(key)
+			} else if value, ok := test.values[key]; ok {
+				cache.Set(key, value, NO_EXPIRATION)
+			} else {
+				cache.Set(key, key, NO_EXPIRATION)
+			}
+		}
+
+		for _, key := range test.present {
+			if _, found := cache.Get(key); !found {
+				t.Errorf("%v: %v evicted", test.name, key)
+			}
+		}
+
+		for _, key := range test.absent {
+			if _, found := cache.Get(key); found {
+				t.Errorf("%v: %v not evicted", test.name, key)
+			}
+		}
+
+		cache.Close()
+	}
+}
+
+func TestBoundedCacheExpiration(t *testing.T) {
+	tests := []struct {
+		name       string
+		expiration time.Duration
+		d          time.Duration
+		found      bool
+	}{
+		{"expired", 0, time.Millisecond, false},
+		{"default expired", time.Millisecond, 0, false},
+		{"not expired", 0, time.Minute, true},
+		{"no expiration", time.Millisecond, NO_EXPIRATION, true},
+	}
+
+	for _, test := range tests {
+		cache := NewBoundedCache(Config{Expiration: test.expiration})
+		cache.Set("1@a", "value", test.d)
+		time.Sleep(5 * time.Millisecond)
+
+		if _, found := cache.Get("1@a"); found != test.found {
+			t.Errorf("%v: found %v, want %v", test.name, found, test.found)
+		}
+
+		cache.Close()
+	}
+}
+
+func TestBoundedCacheStats(t *testing.T) {
+	cache := NewBoundedCache(Config{MaxEntries: 2})
+	defer cache.Close()
+	cache.Set("1@a", "abc", 0)
+	cache.Set("2@a", "de", 0)
+	cache.Get("1@a")
+	cache.Get("3@a")
+	cache.Set("1@b", "f", 0)
+
+	tests := []struct {
+		table string
+		want  CacheStats
+	}{
+		{"a", CacheStats{Hits: 1, Misses: 1, Evictions: 1, Entries: 1, Bytes: 3}},
+		{"b", CacheStats{Entries: 1, Bytes: 1}},
+	}
+
+	stats := cache.Stats()
+
+	for _, test := range tests {
+		if stats[test.table] != test.want {
+			t.Errorf("Stats()[%v] = %+v, want %+v", test.table, stats[test.table], test.want)
+		}
+	}
+
+	if rate := stats["a"].HitRate(); rate != 0.5 {
+		t.Errorf("HitRate() = %v, want 0.5", rate)
+	}
+}
+
+func TestTypedAccess(t *testing.T) {
+	Set("typed", "1@typed", 42, 0)
+	Set("typed", "2@typed", "42", 0)
+
+	if value, ok := Get[int]("1@typed"); !ok || value != 42 {
+		t.Errorf("Get[int] = %v %v, want 42 true", value, ok)
+	}
+
+	if value, ok := Get[int]("2@typed"); ok || value != 0 {
+		t.Errorf("Get[int] of a string = %v %v, want 0 false", value, ok)
+	}
+
+	if _, ok := Get[string]("3@typed"); ok {
+		t.Errorf("Get[string] of a missing key found")
+	}
+}
+
+func TestTableOf(t *testing.T) {
+	tests := []struct {
+		key   string
+		table string
+	}{
+		{"1@user", "user"},
+		{"1@user#3", "user"},
+		{"a@b@user", "user"},
+		{"user?id=1", "user"},
+		{"user?email=a@b", "user"},
+		{"plain", ""},
+	}
+
+	for _, test := range tests {
+		if table := tableOf(test.key); table != test.table {
+			t.Errorf("tableOf(%v) = %v, want %v", test.key, table, test.table)
+		}
+	}
+}
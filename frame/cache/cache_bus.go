@@ -0,0 +1,271 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	redispool "github.com/derekyu332/goii/frame/redis"
+	"github.com/derekyu332/goii/helper/logger"
+	"github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CACHE_CHANNEL     = "goii:cache:evict"
+	CACHE_BUS_RETRY   = 3 * time.Second
+	CACHE_LEVEL_LOCAL = "local"
+	CACHE_LEVEL_REDIS = "redis"
+)
+
+var (
+	gBus     *cacheBus
+	gBusLock sync.RWMutex
+
+	gCacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "goii",
+		Subsystem: "cache",
+		Name:      "hits_total",
+		Help:      "How many cache lookups hit, partitioned by table and level.",
+	}, []string{"table", "level"})
+	gCacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "goii",
+		Subsystem: "cache",
+		Name:      "misses_total",
+		Help:      "How many cache lookups missed, partitioned by table.",
+	}, []string{"table"})
+	gCacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "goii",
+		Subsystem: "cache",
+		Name:      "evictions_total",
+		Help:      "How many local cache keys were evicted, partitioned by table and origin.",
+	}, []string{"table", "origin"})
+)
+
+type evictMessage struct {
+	Origin      string           `json:"origin"`
+	Table       string           `json:"table"`
+	Keys        []string         `json:"keys"`
+	Generations map[string]int64 `json:"generations,omitempty"`
+}
+
+type cacheBus struct {
+	connection string
+	origin     string
+	done       chan bool
+	conn       *redis.PubSubConn
+	lock       sync.Mutex
+}
+
+func init() {
+	prometheus.MustRegister(gCacheHits, gCacheMisses, gCacheEvictions, newStatsCollector())
+}
+
+// StartBus subscribes to the eviction channel on the named redis connection. Keys evicted
+// or overwritten locally are published there and dropped by every other instance.
+func StartBus(connection string) error {
+	if redispool.GetNamedPool(connection) == nil {
+		return fmt.Errorf("Connection %v not initialized", connection)
+	}
+
+	StopBus()
+	hostname, _ := os.Hostname()
+	bus := &cacheBus{
+		connection: connection,
+		origin:     fmt.Sprintf("%v-%v-%v", hostname, os.Getpid(), time.Now().UnixNano()),
+		done:       make(chan bool),
+	}
+
+	gBusLock.Lock()
+	gBus = bus
+	gBusLock.Unlock()
+
+	go bus.run()
+	logger.Warning("Cache bus %v started", connection)
+
+	return nil
+}
+
+func StopBus() {
+	gBusLock.Lock()
+	bus := gBus
+	gBus = nil
+	gBusLock.Unlock()
+
+	if bus == nil {
+		return
+	}
+
+	close(bus.done)
+	bus.lock.Lock()
+
+	if bus.conn != nil {
+		bus.conn.Unsubscribe()
+	}
+
+	bus.lock.Unlock()
+	logger.Warning("Cache bus %v stopped", bus.connection)
+}
+
+// Drops keys from the local cache of every instance.
+func Evict(table string, keys ...string) {
+	for _, key := range keys {
+		LocalCache().Delete(key)
+	}
+
+	gCacheEvictions.WithLabelValues(table, "self").Add(float64(len(keys)))
+	publish(table, keys...)
+}
+
+func publish(table string, keys ...string) {
+	if len(keys) > 0 {
+		send(&evictMessage{Table: table, Keys: keys})
+	}
+}
+
+// Other instances take the new generation instead of reading it from redis.
+func publishGeneration(table string, field string, gen int64) {
+	send(&evictMessage{Table: table, Generations: map[string]int64{field: gen}})
+}
+
+func send(message *evictMessage) {
+	gBusLock.RLock()
+	bus := gBus
+	gBusLock.RUnlock()
+
+	if bus == nil {
+		return
+	}
+
+	pool := redispool.GetNamedPool(bus.connection)
+
+	if pool == nil {
+		return
+	}
+
+	message.Origin = bus.origin
+	data, _ := json.Marshal(message)
+	session := pool.Get()
+	defer session.Close()
+
+	if _, err := session.Do("PUBLISH", CACHE_CHANNEL, data); err != nil {
+		logger.Warning("Cache bus publish %v failed %v", message.Table, err.Error())
+	}
+}
+
+func (this *cacheBus) run() {
+	for {
+		err := this.subscribe()
+
+		select {
+		case <-this.done:
+			return
+		default:
+		}
+
+		logger.Warning("Cache bus %v lost %v, retry in %v", this.connection, err, CACHE_BUS_RETRY)
+
+		select {
+		case <-this.done:
+			return
+		case <-time.After(CACHE_BUS_RETRY):
+		}
+	}
+}
+
+func (this *cacheBus) subscribe() error {
+	pool := redispool.GetNamedPool(this.connection)
+
+	if pool == nil {
+		return fmt.Errorf("Connection %v not initialized", this.connection)
+	}
+
+	conn := &redis.PubSubConn{Conn: pool.Get()}
+	defer conn.Close()
+
+	if err := conn.Subscribe(CACHE_CHANNEL); err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+
+	select {
+	case <-this.done:
+		this.lock.Unlock()
+		return nil
+	default:
+	}
+
+	this.conn = conn
+	this.lock.Unlock()
+
+	defer func() {
+		this.lock.Lock()
+		this.conn = nil
+		this.lock.Unlock()
+	}()
+
+	for {
+		switch v := conn.ReceiveWithTimeout(0).(type) {
+		case redis.Message:
+			this.evict(v.Data)
+		case redis.Subscription:
+			if v.Count == 0 {
+				return nil
+			}
+
+			// Evictions published while the bus was down are lost, drop what may be stale.
+			LocalCache().Flush()
+			logger.Warning("Cache bus %v subscribed, local cache flushed", this.connection)
+		case error:
+			return v
+		}
+	}
+}
+
+func (this *cacheBus) evict(data []byte) {
+	message := &evictMessage{}
+
+	if err := json.Unmarshal(data, message); err != nil {
+		logger.Warning("Cache bus decode failed %v", err.Error())
+		return
+	}
+
+	if message.Origin == this.origin {
+		return
+	}
+
+	for _, key := range message.Keys {
+		LocalCache().Delete(key)
+	}
+
+	for field, gen := range message.Generations {
+		setGeneration(field, gen)
+	}
+
+	if len(message.Keys) > 0 {
+		gCacheEvictions.WithLabelValues(message.Table, "remote").Add(float64(len(message.Keys)))
+	}
+}
+
+// Table of an id key "id@table" or a query key "table?query", optionally followed by
+// "#generation". Other keys have no table.
+func tableOf(key string) string {
+	if i := strings.Index(key, "?"); i >= 0 && !strings.Contains(key[:i], "@") {
+		return key[:i]
+	}
+
+	if i := strings.LastIndex(key, "@"); i >= 0 {
+		key = key[i+1:]
+	} else {
+		return ""
+	}
+
+	if i := strings.Index(key, "#"); i >= 0 {
+		key = key[:i]
+	}
+
+	return key
+}
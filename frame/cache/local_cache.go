@@ -2,7 +2,7 @@ package cache
 
 import (
 	"github.com/derekyu332/goii/frame/base"
-	"github.com/patrickmn/go-cache"
+	"sync"
 	"time"
 )
 
@@ -12,29 +12,58 @@ type ICacheRecord interface {
 	ReadOnly() bool
 }
 
-var gCache *cache.Cache
+var (
+	gCache     *BoundedCache
+	gCacheLock sync.RWMutex
+)
+
+func LocalCache() *BoundedCache {
+	gCacheLock.RLock()
+	local := gCache
+	gCacheLock.RUnlock()
+
+	if local != nil {
+		return local
+	}
+
+	gCacheLock.Lock()
+	defer gCacheLock.Unlock()
 
-func LocalCache() *cache.Cache {
 	if gCache == nil {
-		gCache = cache.New(5*time.Minute, 10*time.Minute)
+		gCache = NewBoundedCache(Config{Policy: POLICY_LRU})
 	}
 
 	return gCache
 }
 
+// Replaces the local cache, cached entries are dropped.
+func InitLocalCache(config *Config) {
+	gCacheLock.Lock()
+	defer gCacheLock.Unlock()
+
+	if gCache != nil {
+		gCache.Close()
+	}
+
+	gCache = NewBoundedCache(*config)
+}
+
 func GetCacheRecord(key string) base.IActiveRecord {
-	if data, found := LocalCache().Get(key); found {
-		if record, ok := data.(base.IActiveRecord); ok {
-			return record
-		}
+	if record, found := Get[base.IActiveRecord](key); found {
+		gCacheHits.WithLabelValues(record.TableName(), CACHE_LEVEL_LOCAL).Inc()
+		return record
 	}
 
+	gCacheMisses.WithLabelValues(tableOf(key)).Inc()
+
 	return nil
 }
 
+// Other instances drop their copy of the key and reload it on next access.
 func SetCacheRecord(record base.IActiveRecord) {
 	if cache_record, ok := record.(ICacheRecord); ok {
-		LocalCache().Set(cache_record.CacheKey(), record,
+		Set(record.TableName(), cache_record.CacheKey(), record,
 			cache_record.CacheExpiration())
+		publish(record.TableName(), cache_record.CacheKey())
 	}
 }
@@ -0,0 +1,331 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/derekyu332/goii/frame/base"
+	redispool "github.com/derekyu332/goii/frame/redis"
+	"github.com/derekyu332/goii/helper/logger"
+	"github.com/gomodule/redigo/redis"
+	"golang.org/x/sync/singleflight"
+	"sync"
+	"time"
+)
+
+const (
+	CACHE_MISSING        = "null"
+	CACHE_GENERATION     = "cache_gen"
+	CACHE_GENERATION_TTL = time.Second
+)
+
+var (
+	errNoShared  = errors.New("Shared cache not configured")
+	gFlight      singleflight.Group
+	gGenerations = make(map[string]*generationEntry)
+	gGenLock     sync.Mutex
+)
+
+// Generations are read from redis at most once per CACHE_GENERATION_TTL, bumps of other
+// instances arrive through the cache bus.
+type generationEntry struct {
+	value   int64
+	fetched time.Time
+}
+
+// Records that are not ReadOnly and implement ISharedCacheRecord are cached in the local
+// cache and in the redis connection it names. Fields excluded from json are not cached.
+type ISharedCacheRecord interface {
+	SharedConnection() string
+}
+
+// Misses are cached for NegativeExpiration, zero disables negative caching.
+type INegativeCacheRecord interface {
+	NegativeExpiration() time.Duration
+}
+
+func Cacheable(record interface{}) bool {
+	if cache_record, ok := record.(ICacheRecord); ok && !cache_record.ReadOnly() {
+		_, ok = record.(ISharedCacheRecord)
+		return ok
+	}
+
+	return false
+}
+
+// Id keys carry the epoch of the table, which only moves on writes that cannot name the
+// records they touch.
+func IdKey(ctx context.Context, record base.IActiveRecord, id interface{}) string {
+	return fmt.Sprintf("%v@%v#%v", id, record.TableName(), generation(ctx, record, epochField(record)))
+}
+
+// Query keys carry the generation of the table, every write bumps it.
+func QueryKey(ctx context.Context, record base.IActiveRecord, query ...interface{}) string {
+	return fmt.Sprintf("%v?%v#%v", record.TableName(), fmt.Sprint(query...), generation(ctx, record, record.TableName()))
+}
+
+// Load looks the key up locally, then in redis, and calls load once per key across
+// concurrent callers on a miss. Load fills record and reports whether it was found.
+func Load(ctx context.Context, key string, record base.IActiveRecord, load func() (bool, error)) (bool, error) {
+	if data, found := Get[[]byte](key); found {
+		gCacheHits.WithLabelValues(record.TableName(), CACHE_LEVEL_LOCAL).Inc()
+		return decode(data, record)
+	}
+
+	// Callers share the flight, so redis is not bound to the context of the first one.
+	load_once := func() (interface{}, error) {
+		if data := sharedGet(context.Background(), record, key); data != nil {
+			gCacheHits.WithLabelValues(record.TableName(), CACHE_LEVEL_REDIS).Inc()
+			LocalCache().SetTable(record.TableName(), key, data, expiration(record, data))
+			return data, nil
+		}
+
+		gCacheMisses.WithLabelValues(record.TableName()).Inc()
+		found, err := load()
+
+		if err != nil {
+			return nil, err
+		}
+
+		data := []byte(CACHE_MISSING)
+
+		if found {
+			if data, err = json.Marshal(record); err != nil {
+				return nil, err
+			}
+		}
+
+		if expire := expiration(record, data); expire > 0 {
+			LocalCache().SetTable(record.TableName(), key, data, expire)
+			sharedSet(context.Background(), record, key, data, expire)
+		}
+
+		return data, nil
+	}
+
+	data, err, shared := gFlight.Do(key, load_once)
+
+	if shared && ctx.Err() == nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		// The flight was run by a caller whose context ended, load on our own.
+		data, err = load_once()
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return decode(data.([]byte), record)
+}
+
+// Write-through of a saved record, query keys of its table are invalidated.
+func Store(ctx context.Context, record base.IActiveRecord) {
+	key := IdKey(ctx, record, record.GetId())
+	data, err := json.Marshal(record)
+
+	if err != nil {
+		logger.Warning("Cache %v encode failed %v", key, err.Error())
+		Invalidate(ctx, record, record.GetId())
+		return
+	}
+
+	expire := expiration(record, data)
+	LocalCache().SetTable(record.TableName(), key, data, expire)
+	sharedSet(ctx, record, key, data, expire)
+	publish(record.TableName(), key)
+	bump(ctx, record, record.TableName())
+}
+
+// Drops the id keys of record's table and invalidates its query keys.
+func Invalidate(ctx context.Context, record base.IActiveRecord, ids ...interface{}) {
+	keys := make([]interface{}, 0, len(ids))
+	evicted := make([]string, 0, len(ids))
+
+	for _, id := range ids {
+		key := IdKey(ctx, record, id)
+		LocalCache().Delete(key)
+		keys = append(keys, key)
+		evicted = append(evicted, key)
+	}
+
+	gCacheEvictions.WithLabelValues(record.TableName(), "self").Add(float64(len(evicted)))
+	publish(record.TableName(), evicted...)
+
+	if len(keys) > 0 {
+		if _, err := sharedDo(ctx, record, "DEL", keys...); err != nil && err != errNoShared {
+			logger.Warning("Cache DEL %v failed %v", keys, err.Error())
+		}
+	}
+
+	bump(ctx, record, record.TableName())
+}
+
+// For writes by condition, every cached record of the table is dropped.
+func InvalidateTable(ctx context.Context, record base.IActiveRecord) {
+	if Cacheable(record) {
+		bump(ctx, record, record.TableName())
+		bump(ctx, record, epochField(record))
+	}
+}
+
+func decode(data []byte, record base.IActiveRecord) (bool, error) {
+	if string(data) == CACHE_MISSING {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(data, record)
+}
+
+func expiration(record base.IActiveRecord, data []byte) time.Duration {
+	if string(data) == CACHE_MISSING {
+		if negative, ok := record.(INegativeCacheRecord); ok {
+			return negative.NegativeExpiration()
+		}
+
+		return 0
+	}
+
+	return record.(ICacheRecord).CacheExpiration()
+}
+
+func epochField(record base.IActiveRecord) string {
+	return record.TableName() + ":epoch"
+}
+
+func generation(ctx context.Context, record base.IActiveRecord, field string) int64 {
+	gGenLock.Lock()
+	entry, ok := gGenerations[field]
+	gGenLock.Unlock()
+
+	if ok && time.Since(entry.fetched) < CACHE_GENERATION_TTL {
+		return entry.value
+	}
+
+	gen, err := redis.Int64(sharedDo(ctx, record, "HGET", CACHE_GENERATION, field))
+
+	if err == redis.ErrNil {
+		gen, err = 0, nil
+	}
+
+	gGenLock.Lock()
+	defer gGenLock.Unlock()
+
+	if err != nil {
+		if err != errNoShared {
+			logger.Warning("Cache generation %v failed %v", field, err.Error())
+		}
+
+		// Without redis the local generation is the only one, keep it.
+		if entry, ok = gGenerations[field]; !ok {
+			entry = &generationEntry{}
+			gGenerations[field] = entry
+		}
+
+		entry.fetched = time.Now()
+
+		return entry.value
+	}
+
+	gGenerations[field] = &generationEntry{value: gen, fetched: time.Now()}
+
+	return gen
+}
+
+func setGeneration(field string, gen int64) {
+	gGenLock.Lock()
+	defer gGenLock.Unlock()
+
+	gGenerations[field] = &generationEntry{value: gen, fetched: time.Now()}
+}
+
+func bump(ctx context.Context, record base.IActiveRecord, field string) {
+	gen, err := redis.Int64(sharedDo(ctx, record, "HINCRBY", CACHE_GENERATION, field, 1))
+
+	if err != nil {
+		if err != errNoShared {
+			logger.Warning("Cache bump %v failed %v", field, err.Error())
+		}
+
+		gGenLock.Lock()
+		entry, ok := gGenerations[field]
+
+		if !ok {
+			entry = &generationEntry{fetched: time.Now()}
+			gGenerations[field] = entry
+		}
+
+		entry.value++
+		gen = entry.value
+		gGenLock.Unlock()
+	} else {
+		setGeneration(field, gen)
+	}
+
+	publishGeneration(record.TableName(), field, gen)
+}
+
+func sharedGet(ctx context.Context, record base.IActiveRecord, key string) []byte {
+	data, err := redis.Bytes(sharedDo(ctx, record, "GET", key))
+
+	if err != nil && err != redis.ErrNil && err != errNoShared {
+		logger.Warning("Cache GET %v failed %v", key, err.Error())
+	}
+
+	return data
+}
+
+func sharedSet(ctx context.Context, record base.IActiveRecord, key string, data []byte, expire time.Duration) {
+	if expire <= 0 {
+		return
+	}
+
+	if _, err := sharedDo(ctx, record, "SET", key, data, "PX", expire.Milliseconds()); err != nil &&
+		err != errNoShared {
+		logger.Warning("Cache SET %v failed %v", key, err.Error())
+	}
+}
+
+func sharedDo(ctx context.Context, record interface{}, cmd string, args ...interface{}) (interface{}, error) {
+	shared, ok := record.(ISharedCacheRecord)
+
+	if !ok {
+		return nil, errNoShared
+	}
+
+	pool := redispool.GetNamedPool(shared.SharedConnection())
+
+	if pool == nil {
+		return nil, errNoShared
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	session := pool.Get()
+	defer session.Close()
+
+	return redis.DoContext(session, ctx, cmd, args...)
+}
+
+// Invalidates every cacheable record, grouped by table.
+func InvalidateRecords(ctx context.Context, records []base.IActiveRecord) {
+	var tables []base.IActiveRecord
+	ids := make(map[string][]interface{})
+
+	for _, record := range records {
+		if !Cacheable(record) {
+			continue
+		}
+
+		if _, ok := ids[record.TableName()]; !ok {
+			tables = append(tables, record)
+		}
+
+		ids[record.TableName()] = append(ids[record.TableName()], record.GetId())
+	}
+
+	for _, record := range tables {
+		Invalidate(ctx, record, ids[record.TableName()]...)
+	}
+}
@@ -0,0 +1,129 @@
+package frame
+
+import (
+	"context"
+	"fmt"
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/helper/logger"
+	"sort"
+	"strings"
+)
+
+const (
+	visitNone = iota
+	visitActive
+	visitDone
+)
+
+func sortComponents(components map[string]base.IComponent) ([]string, error) {
+	ids := make([]string, 0, len(components))
+
+	for id := range components {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+	order := make([]string, 0, len(ids))
+	state := make(map[string]int)
+	var path []string
+	var visit func(id string) error
+
+	visit = func(id string) error {
+		switch state[id] {
+		case visitDone:
+			return nil
+		case visitActive:
+			return fmt.Errorf("component dependency cycle: %v -> %v", strings.Join(path, " -> "), id)
+		}
+
+		com, ok := components[id]
+
+		if !ok {
+			return fmt.Errorf("component %v required by %v not found", id, path[len(path)-1])
+		}
+
+		state[id] = visitActive
+		path = append(path, id)
+
+		if dependent, ok := com.(base.IDependent); ok {
+			for _, dep := range dependent.Dependencies() {
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[id] = visitDone
+		order = append(order, id)
+
+		return nil
+	}
+
+	for _, id := range ids {
+		if err := visit(id); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+func (this *App) initComponents() error {
+	order, err := sortComponents(this.Components)
+
+	if err != nil {
+		return err
+	}
+
+	for _, id := range order {
+		if err := this.Components[id].Initialize(); err != nil {
+			return err
+		}
+
+		logger.Warning("Component %v Initialize Success", id)
+	}
+
+	// Only components that all initialized are started, checked and stopped.
+	this.componentOrder = order
+
+	return nil
+}
+
+// On failure the components already started are stopped in reverse order.
+func (this *App) startComponents() error {
+	for _, id := range this.componentOrder {
+		if starter, ok := this.Components[id].(base.IStartable); ok {
+			if err := starter.Start(); err != nil {
+				logger.Error("Component %v Start failed %v", id, err.Error())
+				ctx, cancel := context.WithTimeout(context.Background(), this.shutdownTimeout())
+				this.stopComponents(ctx)
+				cancel()
+
+				return err
+			}
+
+			logger.Warning("Component %v Start Success", id)
+		}
+
+		this.componentStarted = append(this.componentStarted, id)
+	}
+
+	return nil
+}
+
+func (this *App) stopComponents(ctx context.Context) []error {
+	var errs []error
+
+	for i := len(this.componentStarted) - 1; i >= 0; i-- {
+		id := this.componentStarted[i]
+
+		if stopper, ok := this.Components[id].(base.IStoppable); ok {
+			errs = append(errs, this.shutdownStep(ctx, "Component "+id, stopper.Stop))
+		}
+	}
+
+	this.componentStarted = nil
+
+	return errs
+}
@@ -0,0 +1,226 @@
+package frame
+
+import (
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/frame/cache"
+	"github.com/derekyu332/goii/frame/mongo"
+	"github.com/derekyu332/goii/frame/rabbit"
+	"github.com/derekyu332/goii/frame/redis"
+	"github.com/derekyu332/goii/frame/sql"
+	"github.com/globalsign/mgo"
+	"sort"
+	"time"
+)
+
+func (this *App) sqlConfigs() map[string]*SqlConfig {
+	configs := make(map[string]*SqlConfig)
+
+	for name, config := range this.SqlInits {
+		if config != nil {
+			configs[name] = config
+		}
+	}
+
+	if this.SqlInit != nil {
+		configs[base.DEFAULT_CONNECTION] = this.SqlInit
+	}
+
+	return configs
+}
+
+func (this *App) mongoConfigs() map[string]*MongoConfig {
+	configs := make(map[string]*MongoConfig)
+
+	for name, config := range this.MongoInits {
+		if config != nil {
+			configs[name] = config
+		}
+	}
+
+	if this.MongoInit != nil {
+		configs[base.DEFAULT_CONNECTION] = this.MongoInit
+	}
+
+	return configs
+}
+
+func (this *App) redisConfigs() map[string]*RedisConfig {
+	configs := make(map[string]*RedisConfig)
+
+	for name, config := range this.RedisInits {
+		if config != nil {
+			configs[name] = config
+		}
+	}
+
+	if this.RedisInit != nil {
+		configs[base.DEFAULT_CONNECTION] = this.RedisInit
+	}
+
+	return configs
+}
+
+func (this *App) rabbitConfigs() map[string]*RabbitConfig {
+	configs := make(map[string]*RabbitConfig)
+
+	for name, config := range this.RabbitInits {
+		if config != nil {
+			configs[name] = config
+		}
+	}
+
+	if this.RabbitInit != nil {
+		configs[rabbit.DEFAULT_PRODUCER] = this.RabbitInit
+	}
+
+	return configs
+}
+
+func connectionNames(configs interface{}) []string {
+	var names []string
+
+	switch m := configs.(type) {
+	case map[string]*SqlConfig:
+		for name := range m {
+			names = append(names, name)
+		}
+	case map[string]*MongoConfig:
+		for name := range m {
+			names = append(names, name)
+		}
+	case map[string]*RedisConfig:
+		for name := range m {
+			names = append(names, name)
+		}
+	case map[string]*RabbitConfig:
+		for name := range m {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func (this *App) initSql() error {
+	if err := this.connectSql(); err != nil {
+		return err
+	}
+
+	configs := this.sqlConfigs()
+
+	for _, name := range connectionNames(configs) {
+		if configs[name].Audit {
+			if err := sql.SyncAuditTable(name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Opens the engines without touching any table.
+func (this *App) connectSql() error {
+	configs := this.sqlConfigs()
+
+	for _, name := range connectionNames(configs) {
+		config := configs[name]
+		policy, err := sql.GroupPolicy(config.Policy, config.Weights, len(config.Replicas))
+
+		if err != nil {
+			return err
+		}
+
+		if err = sql.InitNamedEngineGroup(name, config.Driver, config.Uri, config.Replicas, policy,
+			config.MaxIdleConns); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (this *App) initMongo() error {
+	if err := this.connectMongo(); err != nil {
+		return err
+	}
+
+	return mongo.SyncIndexes()
+}
+
+// Opens the connections without syncing indexes.
+func (this *App) connectMongo() error {
+	configs := this.mongoConfigs()
+
+	for _, name := range connectionNames(configs) {
+		config := configs[name]
+
+		if config.Driver == mongo.DRIVER_OFFICIAL {
+			if err := mongo.InitNamedDriverConnection(name, config.Url, config.DbName); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		mongoConfig, err := mgo.ParseURL(config.Url)
+
+		if err != nil {
+			return err
+		}
+
+		mongoConfig.PoolTimeout = 3000 * time.Millisecond
+		mongoConfig.ReadTimeout = 10000 * time.Millisecond
+		mongoConfig.WriteTimeout = 5000 * time.Millisecond
+		mongoConfig.MaxIdleTimeMS = 60000
+		mongoConfig.Safe = mgo.Safe{
+			W:        1,
+			WTimeout: 5000,
+		}
+
+		if err = mongo.InitNamedConnection(name, mongoConfig, config.DbName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (this *App) initRedis() error {
+	configs := this.redisConfigs()
+
+	for _, name := range connectionNames(configs) {
+		config := configs[name]
+
+		if err := redis.InitNamedConnection(name, config.Url, config.Password); err != nil {
+			return err
+		}
+	}
+
+	if this.CacheBus != "" {
+		return cache.StartBus(this.CacheBus)
+	}
+
+	return nil
+}
+
+func (this *App) initRabbit() error {
+	configs := this.rabbitConfigs()
+
+	for _, name := range connectionNames(configs) {
+		config := configs[name]
+
+		if err := rabbit.InitNamedProducer(name, config.AmqpURI, config.InitCap, config.MaxCap,
+			config.InitCap); err != nil {
+			return err
+		}
+	}
+
+	if this.RabbitInit != nil && this.RabbitInit.OpenRpc {
+		return rabbit.InitConsumer(this.RabbitInit.AmqpURI, this.RabbitInit.RpcQueue, this.RabbitInit.RpcRoutineKey)
+	}
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"sync"
 	"time"
 )
 
@@ -22,9 +23,12 @@ type EtherSubscriber struct {
 	filterAddresses []string
 	done            chan bool
 	handler         EtherHandler
+	handlerGroup    sync.WaitGroup
+	loopGroup       sync.WaitGroup
+	closeOnce       sync.Once
 }
 
-func NewSubscriber(dialUrl string, filterAddresses []string, handler EtherHandler) *EtherSubscriber {
+func NewSubscriber(dialUrl string, filterAddresses []string, handler EtherHandler) (*EtherSubscriber, error) {
 	newSubscriber := &EtherSubscriber{
 		dialUrl:         dialUrl,
 		filterAddresses: filterAddresses,
@@ -35,18 +39,50 @@ func NewSubscriber(dialUrl string, filterAddresses []string, handler EtherHandle
 	err := newSubscriber.initHeadSubscriber()
 
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	if len(filterAddresses) > 0 {
 		err = newSubscriber.initLogsSubscriber()
 
 		if err != nil {
-			panic(err)
+			newSubscriber.Close()
+			return nil, err
 		}
 	}
 
-	return newSubscriber
+	return newSubscriber, nil
+}
+
+func (this *EtherSubscriber) Close() {
+	this.closeOnce.Do(func() {
+		close(this.done)
+	})
+
+	// Handlers are added by the subscribe loops, wait for those to stop first.
+	this.loopGroup.Wait()
+	this.handlerGroup.Wait()
+	logger.Warning("EtherSubscriber Closed")
+}
+
+func (this *EtherSubscriber) handle(topic string, body []byte) {
+	this.handlerGroup.Add(1)
+
+	go func() {
+		defer this.handlerGroup.Done()
+		this.handler(topic, body)
+	}()
+}
+
+// A lost loop starts its successor before returning, so loopGroup stays above zero until
+// the loops see done.
+func (this *EtherSubscriber) loop(subscribe func()) {
+	this.loopGroup.Add(1)
+
+	go func() {
+		defer this.loopGroup.Done()
+		subscribe()
+	}()
 }
 
 func (this *EtherSubscriber) initHeadSubscriber() error {
@@ -56,7 +92,7 @@ func (this *EtherSubscriber) initHeadSubscriber() error {
 		return err
 	}
 
-	go this.subscribeHead(client)
+	this.loop(func() { this.subscribeHead(client) })
 	logger.Warning("initHeadSubscriber Success")
 
 	return nil
@@ -80,7 +116,12 @@ func (this *EtherSubscriber) subscribeHead(client *ethclient.Client) error {
 			case header := <-headers:
 				logger.Info("Got New Block %v, Number %v", header.Hash().Hex(), header.Number.String())
 				body, _ := json.Marshal(header)
-				go this.handler("new-heads", body)
+				this.handle("new-heads", body)
+			case <-this.done:
+				sub.Unsubscribe()
+				client.Close()
+				logger.Warning("WebSocket Closed.")
+				return nil
 			}
 
 			if isLost {
@@ -114,7 +155,7 @@ func (this *EtherSubscriber) initLogsSubscriber() error {
 		return err
 	}
 
-	go this.subscribeLogs(client)
+	this.loop(func() { this.subscribeLogs(client) })
 	logger.Warning("initLogsSubscriber Success")
 
 	return nil
@@ -146,7 +187,12 @@ func (this *EtherSubscriber) subscribeLogs(client *ethclient.Client) error {
 			case log := <-filterlogs:
 				logger.Info("Got New Transaction %v, BlockNumber %v", log.TxHash, log.BlockNumber)
 				body, _ := json.Marshal(log)
-				go this.handler("filter-logs", body)
+				this.handle("filter-logs", body)
+			case <-this.done:
+				sub.Unsubscribe()
+				client.Close()
+				logger.Warning("WebSocket Closed.")
+				return nil
 			}
 
 			if isLost {
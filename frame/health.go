@@ -0,0 +1,246 @@
+package frame
+
+import (
+	"context"
+	"fmt"
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/frame/kafka"
+	"github.com/derekyu332/goii/frame/mongo"
+	"github.com/derekyu332/goii/frame/rabbit"
+	"github.com/derekyu332/goii/frame/redis"
+	"github.com/derekyu332/goii/frame/sql"
+	"github.com/derekyu332/goii/helper/logger"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	HEALTH_STATUS_UP        = "UP"
+	HEALTH_STATUS_DOWN      = "DOWN"
+	HEALTH_STATUS_DEGRADED  = "DEGRADED"
+	DEFAULT_HEALTH_TIMEOUT  = 3 * time.Second
+	DEFAULT_HEALTH_INTERVAL = 10 * time.Second
+	HEALTH_LIVENESS_PATH    = "/livez"
+	HEALTH_HEALTH_PATH      = "/healthz"
+	HEALTH_READINESS_PATH   = "/readyz"
+)
+
+type HealthCheckFunc func(ctx context.Context) error
+
+type healthEntry struct {
+	name  string
+	check HealthCheckFunc
+}
+
+type HealthResult struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"duration_ms"`
+}
+
+type HealthReport struct {
+	Status string         `json:"status"`
+	Checks []HealthResult `json:"checks"`
+}
+
+func (this *App) AddHealthCheck(name string, check HealthCheckFunc) {
+	this.healthLock.Lock()
+	defer this.healthLock.Unlock()
+	this.healthChecks = append(this.healthChecks, healthEntry{name: name, check: check})
+}
+
+func (this *App) healthTimeout() time.Duration {
+	if this.HealthTimeout > 0 {
+		return this.HealthTimeout
+	}
+
+	return DEFAULT_HEALTH_TIMEOUT
+}
+
+func (this *App) allHealthChecks() []healthEntry {
+	var checks []healthEntry
+
+	if len(this.sqlConfigs()) > 0 {
+		checks = append(checks, healthEntry{name: SUBSYSTEM_SQL, check: sql.Ping})
+	}
+
+	if len(this.mongoConfigs()) > 0 {
+		checks = append(checks, healthEntry{name: SUBSYSTEM_MONGO, check: mongo.Ping})
+	}
+
+	if len(this.redisConfigs()) > 0 {
+		checks = append(checks, healthEntry{name: SUBSYSTEM_REDIS, check: redis.Ping})
+	}
+
+	if len(this.rabbitConfigs()) > 0 {
+		checks = append(checks, healthEntry{name: SUBSYSTEM_RABBIT, check: rabbit.Ping})
+	}
+
+	if this.KafkaInit != nil {
+		checks = append(checks, healthEntry{name: SUBSYSTEM_KAFKA, check: kafka.Ping})
+	}
+
+	for _, id := range this.componentOrder {
+		if checker, ok := this.Components[id].(base.IHealthChecker); ok {
+			checks = append(checks, healthEntry{name: "component:" + id, check: checker.HealthCheck})
+		}
+	}
+
+	this.healthLock.Lock()
+	checks = append(checks, this.healthChecks...)
+	this.healthLock.Unlock()
+
+	return checks
+}
+
+func (this *App) runHealthCheck(ctx context.Context, entry healthEntry) HealthResult {
+	ctx, cancel := context.WithTimeout(ctx, this.healthTimeout())
+	defer cancel()
+	req_start := time.Now()
+	done := make(chan error, 1)
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("health check panic %v", r)
+			}
+		}()
+
+		done <- entry.check(ctx)
+	}()
+
+	var err error
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := HealthResult{
+		Name:     entry.name,
+		Status:   HEALTH_STATUS_UP,
+		Duration: int64(time.Since(req_start) / time.Millisecond),
+	}
+
+	if err != nil {
+		result.Status = HEALTH_STATUS_DOWN
+		result.Error = err.Error()
+		logger.Warning("Health check %v failed %v", entry.name, err.Error())
+
+		if this.IsDegraded(entry.name) {
+			result.Status = HEALTH_STATUS_DEGRADED
+		}
+	}
+
+	return result
+}
+
+func (this *App) CheckHealth(ctx context.Context) *HealthReport {
+	checks := this.allHealthChecks()
+	report := &HealthReport{
+		Status: HEALTH_STATUS_UP,
+		Checks: make([]HealthResult, len(checks)),
+	}
+	var wg sync.WaitGroup
+
+	for i, entry := range checks {
+		wg.Add(1)
+
+		go func(i int, entry healthEntry) {
+			defer wg.Done()
+			report.Checks[i] = this.runHealthCheck(ctx, entry)
+		}(i, entry)
+	}
+
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status == HEALTH_STATUS_DOWN {
+			report.Status = HEALTH_STATUS_DOWN
+			break
+		}
+	}
+
+	return report
+}
+
+func (this *App) IsReady() bool {
+	return atomic.LoadInt32(&this.ready) == 1
+}
+
+func (this *App) setReady(ready bool) {
+	if ready {
+		atomic.StoreInt32(&this.ready, 1)
+	} else {
+		atomic.StoreInt32(&this.ready, 0)
+	}
+}
+
+func (this *App) registerHealthRoutes() {
+	this.engine.GET(HEALTH_LIVENESS_PATH, func(c *gin.Context) {
+		c.JSON(http.StatusOK, &HealthReport{Status: HEALTH_STATUS_UP, Checks: []HealthResult{}})
+	})
+
+	this.engine.GET(HEALTH_HEALTH_PATH, func(c *gin.Context) {
+		report := this.CheckHealth(c.Request.Context())
+
+		if report.Status == HEALTH_STATUS_UP {
+			c.JSON(http.StatusOK, report)
+		} else {
+			c.JSON(http.StatusServiceUnavailable, report)
+		}
+	})
+
+	this.engine.GET(HEALTH_READINESS_PATH, func(c *gin.Context) {
+		if !this.IsReady() {
+			c.JSON(http.StatusServiceUnavailable, &HealthReport{Status: HEALTH_STATUS_DOWN, Checks: []HealthResult{}})
+			return
+		}
+
+		report := this.CheckHealth(c.Request.Context())
+
+		if report.Status == HEALTH_STATUS_UP {
+			c.JSON(http.StatusOK, report)
+		} else {
+			c.JSON(http.StatusServiceUnavailable, report)
+		}
+	})
+}
+
+func (this *App) registerHealthService() {
+	this.healthServer = health.NewServer()
+	this.healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	grpc_health_v1.RegisterHealthServer(this.server, this.healthServer)
+}
+
+func (this *App) watchHealth(done chan bool) {
+	ticker := time.NewTicker(DEFAULT_HEALTH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		status := grpc_health_v1.HealthCheckResponse_NOT_SERVING
+
+		if this.IsReady() && this.CheckHealth(context.Background()).Status == HEALTH_STATUS_UP {
+			status = grpc_health_v1.HealthCheckResponse_SERVING
+		}
+
+		this.healthServer.SetServingStatus("", status)
+
+		if this.ServiceName != "" {
+			this.healthServer.SetServingStatus(this.ServiceName, status)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
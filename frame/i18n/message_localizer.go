@@ -17,7 +17,9 @@ func InitBundle(messageFiles []string) error {
 	gDefaultBundle.RegisterUnmarshalFunc("toml", toml.Unmarshal)
 
 	for _, path := range messageFiles {
-		gDefaultBundle.MustLoadMessageFile(path)
+		if _, err := gDefaultBundle.LoadMessageFile(path); err != nil {
+			return err
+		}
 	}
 
 	logger.Warning("i18n Bundle Init Success")
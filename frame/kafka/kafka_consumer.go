@@ -8,12 +8,18 @@ import (
 	"github.com/lestrrat-go/file-rotatelogs"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var KAFKA_RECONNECT_DELAY = []time.Duration{100 * time.Millisecond, 500 * time.Millisecond,
 	1000 * time.Millisecond, 2000 * time.Millisecond, 5000 * time.Millisecond}
 
+const (
+	KAFKA_POLL_TIMEOUT = 1 * time.Second
+)
+
 type KafkaHandler func(message *kafka.Message) error
 
 type KafkaConsumer struct {
@@ -25,17 +31,21 @@ type KafkaConsumer struct {
 	SaslUsername     string
 	SaslPassword     string
 	done             chan bool
+	closed           chan bool
 	handler          KafkaHandler
+	handlerGroup     sync.WaitGroup
+	closeOnce        sync.Once
+	connected        int32
 	logger           *zap.Logger
 }
 
 func NewConsumer(topic []string, groupId string, bootstrapServers string, securityProtocol string,
-	saslMechanism string, saslUsername string, saslPassword string, handler KafkaHandler) *KafkaConsumer {
+	saslMechanism string, saslUsername string, saslPassword string, handler KafkaHandler) (*KafkaConsumer, error) {
 	logFile := "../output/app_%Y%m%d.log"
 	rotator, err := rotatelogs.New(logFile, rotatelogs.WithMaxAge(60*24*time.Hour), rotatelogs.WithRotationTime(24*time.Hour))
 
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	encoderConfig := map[string]string{
@@ -47,7 +57,7 @@ func NewConsumer(topic []string, groupId string, bootstrapServers string, securi
 	var encCfg zapcore.EncoderConfig
 
 	if err := json.Unmarshal(data, &encCfg); err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	w := zapcore.AddSync(rotator)
@@ -61,6 +71,7 @@ func NewConsumer(topic []string, groupId string, bootstrapServers string, securi
 		SaslUsername:     saslUsername,
 		SaslPassword:     saslPassword,
 		done:             make(chan bool),
+		closed:           make(chan bool),
 		handler:          handler,
 		logger:           zap.New(core),
 	}
@@ -68,13 +79,25 @@ func NewConsumer(topic []string, groupId string, bootstrapServers string, securi
 	err = newConsumer.initConsumer()
 
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return newConsumer
+	return newConsumer, nil
 }
 
 func (this *KafkaConsumer) initConsumer() error {
+	consumer, err := this.dial()
+
+	if err != nil {
+		return err
+	}
+
+	go this.run(consumer)
+
+	return nil
+}
+
+func (this *KafkaConsumer) dial() (*kafka.Consumer, error) {
 	logger.Info("Init Kafka Consumer, it may take a few seconds to init the connection\n")
 
 	var kafkaconf = &kafka.ConfigMap{
@@ -104,25 +127,72 @@ func (this *KafkaConsumer) initConsumer() error {
 		kafkaconf.SetKey("sasl.mechanism", this.SaslMechanism)
 
 	default:
-		return kafka.NewError(kafka.ErrUnknownProtocol, "unknown protocol", true)
+		return nil, kafka.NewError(kafka.ErrUnknownProtocol, "unknown protocol", true)
 	}
 
 	consumer, err := kafka.NewConsumer(kafkaconf)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	logger.Warning("Init Kafka Consumer success\n")
+	atomic.StoreInt32(&this.connected, 1)
 	consumer.SubscribeTopics(this.Topic, nil)
-	go this.consumeMessage(consumer)
 
-	return nil
+	return consumer, nil
+}
+
+// Polls until Close, reconnecting when the consumer is lost. Handlers are only added on
+// this goroutine, which waits for them before closed is closed on its way out.
+func (this *KafkaConsumer) run(consumer *kafka.Consumer) {
+	defer close(this.closed)
+	defer this.logger.Sync()
+
+	for consumer != nil && this.consumeMessage(consumer) {
+		consumer = this.reconnect()
+	}
+
+	this.handlerGroup.Wait()
+	logger.Warning("Consumer Closed.")
+}
+
+func (this *KafkaConsumer) IsConnected() bool {
+	return atomic.LoadInt32(&this.connected) == 1
 }
 
+func (this *KafkaConsumer) Close() {
+	this.closeOnce.Do(func() {
+		close(this.done)
+	})
+
+	<-this.closed
+}
+
+func (this *KafkaConsumer) closeConsumer(consumer *kafka.Consumer) {
+	this.handlerGroup.Wait()
+
+	if _, err := consumer.Commit(); err != nil {
+		if kafkaErr, ok := err.(kafka.Error); !ok || kafkaErr.Code() != kafka.ErrNoOffset {
+			logger.Warning("Consumer Commit failed %v", err.Error())
+		}
+	}
+
+	consumer.Close()
+	atomic.StoreInt32(&this.connected, 0)
+}
+
+// False once closed, true when the consumer was lost.
 func (this *KafkaConsumer) consumeMessage(consumer *kafka.Consumer) bool {
 	for {
-		msg, err := consumer.ReadMessage(-1)
+		select {
+		case <-this.done:
+			this.closeConsumer(consumer)
+			return false
+		default:
+		}
+
+		msg, err := consumer.ReadMessage(KAFKA_POLL_TIMEOUT)
 
 		if err == nil {
 			this.logger.Info("Got Message",
@@ -130,29 +200,38 @@ func (this *KafkaConsumer) consumeMessage(consumer *kafka.Consumer) bool {
 				zap.Int32("partition", msg.TopicPartition.Partition),
 				zap.Int64("offset", int64(msg.TopicPartition.Offset)),
 				zap.String("content", extend.BytesString(msg.Value)))
-			go this.handler(msg)
+			this.handlerGroup.Add(1)
+
+			go func(msg *kafka.Message) {
+				defer this.handlerGroup.Done()
+				this.handler(msg)
+			}(msg)
+		} else if kafkaErr, ok := err.(kafka.Error); ok && kafkaErr.Code() == kafka.ErrTimedOut {
+			continue
 		} else {
+			atomic.StoreInt32(&this.connected, 0)
 			logger.Warning("ReadMessage error: %v (%v)\n", err, msg)
 			consumer.Close()
-			break
+
+			return true
 		}
 	}
+}
 
-	defer this.logger.Sync()
+// Nil once closed.
+func (this *KafkaConsumer) reconnect() *kafka.Consumer {
 	retry_times := 0
 	logger.Warning("Consumer Reconnect")
 
 	for {
 		select {
 		case <-this.done:
-			consumer.Close()
-			logger.Warning("Consumer Closed.")
-			return false
+			return nil
 		case <-time.After(KAFKA_RECONNECT_DELAY[retry_times]):
 			logger.Warning("Consumer Retrying Init...Retry %v", retry_times)
-			if this.initConsumer() == nil {
-				retry_times = 0
-				return true
+
+			if consumer, err := this.dial(); err == nil {
+				return consumer
 			}
 
 			if retry_times < len(KAFKA_RECONNECT_DELAY)-1 {
@@ -160,6 +239,4 @@ func (this *KafkaConsumer) consumeMessage(consumer *kafka.Consumer) bool {
 			}
 		}
 	}
-
-	return true
 }
@@ -1,6 +1,7 @@
 package kafka
 
 import (
+	"context"
 	"errors"
 )
 
@@ -10,12 +11,24 @@ var (
 
 func InitWorker(topic []string, groupId string, bootstrapServers string, securityProtocol string,
 	saslMechanism string, saslUsername string, saslPassword string, handler KafkaHandler) error {
-	gService = NewConsumer(topic, groupId, bootstrapServers, securityProtocol, saslMechanism,
+	var err error
+	gService, err = NewConsumer(topic, groupId, bootstrapServers, securityProtocol, saslMechanism,
 		saslUsername, saslPassword, handler)
 
-	if gService == nil {
-		return errors.New("Unexpected error")
+	return err
+}
+
+func Ping(ctx context.Context) error {
+	if gService == nil || !gService.IsConnected() {
+		return errors.New("Consumer not connected")
 	}
 
 	return nil
 }
+
+func CloseWorker() {
+	if gService != nil {
+		gService.Close()
+		gService = nil
+	}
+}
@@ -0,0 +1,122 @@
+package frame
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/frame/mongo"
+	"github.com/derekyu332/goii/frame/sql"
+	"github.com/derekyu332/goii/helper/logger"
+	"os"
+	"text/tabwriter"
+)
+
+const (
+	MIGRATE_UP     = "up"
+	MIGRATE_DOWN   = "down"
+	MIGRATE_STATUS = "status"
+)
+
+// Migrate runs "up", "down" or "status" against a single sql or mongo connection, e.g.
+// app migrate up -store=sql -connection=default -steps=1. Migrations must be registered beforehand.
+// Only the connections are opened, audit tables and indexes are left to the migrations.
+func (this *App) Migrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down|status [-store=sql|mongo] [-connection=name] [-steps=n]")
+	}
+
+	switch args[0] {
+	case MIGRATE_UP, MIGRATE_DOWN, MIGRATE_STATUS:
+	default:
+		return fmt.Errorf("unknown migrate command %v", args[0])
+	}
+
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	store := fs.String("store", SUBSYSTEM_SQL, "sql or mongo")
+	connection := fs.String("connection", base.DEFAULT_CONNECTION, "connection name")
+	steps := fs.Int("steps", 0, "steps to apply or roll back")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	gApp = this
+	logger.SetLevel((int)(this.LogLevel))
+	ctx := context.Background()
+
+	switch *store {
+	case SUBSYSTEM_SQL:
+		if _, ok := this.sqlConfigs()[*connection]; !ok {
+			return fmt.Errorf("sql connection %v not configured", *connection)
+		}
+
+		if err := this.connectSql(); err != nil {
+			return &StartupError{Subsystem: SUBSYSTEM_SQL, Err: err}
+		}
+
+		defer sql.CloseEngine()
+
+		switch args[0] {
+		case MIGRATE_UP:
+			return sql.Migrate(ctx, *connection, *steps)
+		case MIGRATE_DOWN:
+			return sql.Rollback(ctx, *connection, *steps)
+		case MIGRATE_STATUS:
+			statuses, err := sql.MigrationStatuses(*connection)
+
+			if err != nil {
+				return err
+			}
+
+			return printMigrationStatuses(statuses)
+		}
+	case SUBSYSTEM_MONGO:
+		if _, ok := this.mongoConfigs()[*connection]; !ok {
+			return fmt.Errorf("mongo connection %v not configured", *connection)
+		}
+
+		if err := this.connectMongo(); err != nil {
+			return &StartupError{Subsystem: SUBSYSTEM_MONGO, Err: err}
+		}
+
+		defer mongo.CloseConnection()
+
+		switch args[0] {
+		case MIGRATE_UP:
+			return mongo.Migrate(ctx, *connection, *steps)
+		case MIGRATE_DOWN:
+			return mongo.Rollback(ctx, *connection, *steps)
+		case MIGRATE_STATUS:
+			statuses, err := mongo.MigrationStatuses(*connection)
+
+			if err != nil {
+				return err
+			}
+
+			return printMigrationStatuses(statuses)
+		}
+	default:
+		return fmt.Errorf("unknown store %v", *store)
+	}
+
+	return fmt.Errorf("unknown migrate command %v", args[0])
+}
+
+func printMigrationStatuses(statuses []base.MigrationStatus) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+
+	for _, status := range statuses {
+		applied := "pending"
+
+		if status.Applied {
+			applied = status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\n", status.Version, status.Name, applied)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,76 @@
+package frame
+
+import (
+	"errors"
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/helper/logger"
+	"os"
+	"sync"
+)
+
+const (
+	MODULE_SO_NAME = "module.so"
+)
+
+type ModuleFactory func() base.IModule
+
+var (
+	gModuleFactory ModuleFactory
+	gModuleLock    sync.RWMutex
+)
+
+func RegisterModule(factory ModuleFactory) {
+	gModuleLock.Lock()
+	defer gModuleLock.Unlock()
+	gModuleFactory = factory
+}
+
+func registeredModule() base.IModule {
+	gModuleLock.RLock()
+	defer gModuleLock.RUnlock()
+
+	if gModuleFactory == nil {
+		return nil
+	}
+
+	return gModuleFactory()
+}
+
+func pluginExists(soPath string) bool {
+	if soPath == "" {
+		return false
+	}
+
+	_, err := os.Stat(soPath + MODULE_SO_NAME)
+
+	return err == nil
+}
+
+func loadModule(soPath string) (base.IModule, error) {
+	if !pluginExists(soPath) {
+		if m := registeredModule(); m != nil {
+			logger.Warning("Load Registered Module")
+			return m, nil
+		}
+	}
+
+	if soPath == "" {
+		soPath = defaultSoPath()
+	}
+
+	m, err := base.LoadPlugin(soPath, MODULE_SO_NAME)
+
+	if err != nil {
+		return nil, err
+	}
+
+	module, ok := m.(base.IModule)
+
+	if !ok {
+		return nil, errors.New("IModule not implemented")
+	}
+
+	logger.Warning("Load Module %v%v", soPath, MODULE_SO_NAME)
+
+	return module, nil
+}
@@ -0,0 +1,470 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/frame/ether"
+	goiikafka "github.com/derekyu332/goii/frame/kafka"
+	"github.com/derekyu332/goii/helper/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/gocraft/work"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	gRequestID int64
+)
+
+func init() {
+	gRequestID = time.Now().UnixNano() / int64(time.Millisecond) * 1000
+}
+
+func NextRequestID() int64 {
+	return atomic.AddInt64(&gRequestID, 1)
+}
+
+type WebModule struct {
+	PollHandler  goiikafka.KafkaHandler
+	EtherHandler ether.EtherHandler
+	engine       *gin.Engine
+	controllers  []base.IController
+}
+
+func (this *WebModule) SetEngine(eg *gin.Engine) {
+	this.engine = eg
+}
+
+func (this *WebModule) GetEngine() *gin.Engine {
+	return this.engine
+}
+
+func (this *WebModule) GetControllers() []base.IController {
+	return this.controllers
+}
+
+func (this *WebModule) SetControllers(controllers []base.IController) {
+	this.controllers = controllers
+}
+
+func (this *WebModule) newController(regController base.IController) base.IController {
+	v := reflect.ValueOf(regController)
+
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return regController
+	}
+
+	nv := reflect.New(v.Elem().Type())
+	nv.Elem().Set(v.Elem())
+
+	if controller, ok := nv.Interface().(base.IController); ok {
+		return controller
+	}
+
+	return regController
+}
+
+func (this *WebModule) findRoute(controller base.IController, relativePath string) *base.Route {
+	for _, route := range controller.RoutesMap() {
+		if route.RelativePath == relativePath {
+			r := route
+			return &r
+		}
+	}
+
+	return nil
+}
+
+func (this *WebModule) authenticate(controller base.IController, c *gin.Context) error {
+	identity := controller.GetIdentity()
+
+	if identity == nil {
+		return nil
+	}
+
+	if err := identity.Authenticate(c); err != nil {
+		logger.Warning("[%v] Authenticate failed %v", c.GetInt64(base.KEY_REQUEST_ID), err.Error())
+
+		if httpErr, ok := err.(*base.HttpError); ok {
+			return httpErr
+		}
+
+		return base.NotAuthenticatedHttpError(c, "")
+	}
+
+	if err := identity.Authorize(c); err != nil {
+		logger.Warning("[%v] Authorize failed %v", c.GetInt64(base.KEY_REQUEST_ID), err.Error())
+
+		if httpErr, ok := err.(*base.HttpError); ok {
+			return httpErr
+		}
+
+		return base.NotAuthorizedHttpError(c, "")
+	}
+
+	if identity.IsAuthorized() {
+		c.Set(base.KEY_IDENTITY, fmt.Sprintf("%v", identity.GetId()))
+	}
+
+	return nil
+}
+
+func (this *WebModule) checkRateLimit(controller base.IController, c *gin.Context) error {
+	rateLimit := controller.GetRateLimit()
+
+	if rateLimit == nil {
+		return nil
+	}
+
+	limit, window := rateLimit.GetRateLimit(c)
+
+	if limit <= 0 || window <= 0 {
+		return nil
+	}
+
+	allowance, timestamp := rateLimit.LoadAllowance(c)
+	now := time.Now().Unix()
+	allowance += (now - timestamp) * limit / window
+
+	if allowance > limit {
+		allowance = limit
+	}
+
+	c.Header("X-Rate-Limit-Limit", strconv.FormatInt(limit, 10))
+
+	if allowance < 1 {
+		rateLimit.SaveAllowance(c, 0)
+		c.Header("X-Rate-Limit-Remaining", "0")
+		c.Header("X-Rate-Limit-Reset", strconv.FormatInt(window/limit, 10))
+		logger.Warning("[%v] Rate limit exceeded", c.GetInt64(base.KEY_REQUEST_ID))
+
+		return base.TooManyRequestHttpError(c, "")
+	}
+
+	rateLimit.SaveAllowance(c, allowance-1)
+	c.Header("X-Rate-Limit-Remaining", strconv.FormatInt(allowance-1, 10))
+	c.Header("X-Rate-Limit-Reset", "0")
+
+	return nil
+}
+
+func (this *WebModule) errorResponse(controller base.IController, c *gin.Context, err error) (int, map[string]interface{}) {
+	httpErr, ok := err.(*base.HttpError)
+
+	if !ok {
+		httpErr = base.ServerUnexpectedHttpError(c, "")
+	}
+
+	return httpErr.Code, map[string]interface{}{
+		controller.TitleRet():     httpErr.Ret,
+		controller.TitleMessage(): httpErr.Message,
+	}
+}
+
+// Runs route on a per-request copy of regController, which is returned for formatting.
+func (this *WebModule) runController(regController base.IController, route *base.Route,
+	c *gin.Context) (base.IController, int, map[string]interface{}) {
+	if _, exists := c.Get(base.KEY_REQUEST_ID); !exists {
+		c.Set(base.KEY_REQUEST_ID, NextRequestID())
+	}
+
+	if trace_id := c.GetHeader(base.HEADER_TRACE_ID); trace_id != "" && c.GetString(base.KEY_TRACE_ID) == "" {
+		c.Set(base.KEY_TRACE_ID, trace_id)
+	}
+
+	controller := this.newController(regController)
+	controller.PreparedForUse(c)
+
+	if err := this.authenticate(controller, c); err != nil {
+		code, response := this.errorResponse(controller, c, err)
+		return controller, code, response
+	}
+
+	if err := this.checkRateLimit(controller, c); err != nil {
+		code, response := this.errorResponse(controller, c, err)
+		return controller, code, response
+	}
+
+	behaviors := controller.Behaviors()
+	passed := 0
+	var code int
+	var response map[string]interface{}
+
+	for _, behavior := range behaviors {
+		if err := behavior.BeforeAction(c); err != nil {
+			code, response = this.errorResponse(controller, c, err)
+			break
+		}
+
+		passed++
+	}
+
+	// A cached response can not answer a service call, it lacks the typed reply.
+	if response == nil && !c.GetBool(base.KEY_SERVICE) {
+		if cached, exists := c.Get(base.KEY_RESPONSE); exists {
+			response, _ = cached.(map[string]interface{})
+			code = http.StatusOK
+		}
+	}
+
+	if response == nil {
+		response = route.Handler(c)
+		code = http.StatusOK
+	}
+
+	if response != nil {
+		if ret, ok := response[controller.TitleRet()]; ok {
+			c.Set(base.KEY_ACTION_RET, ret)
+		}
+	}
+
+	c.Set(base.KEY_RESPONSE, response)
+
+	for i := passed - 1; i >= 0; i-- {
+		if err := behaviors[i].AfterAction(c); err != nil {
+			logger.Warning("[%v] AfterAction failed %v", c.GetInt64(base.KEY_REQUEST_ID), err.Error())
+			code, response = this.errorResponse(controller, c, err)
+		}
+	}
+
+	if response != nil {
+		if ret, ok := response[controller.TitleRet()]; ok {
+			c.Set(base.KEY_ACTION_RET, ret)
+		}
+	}
+
+	return controller, code, response
+}
+
+func (this *WebModule) RunAction(regController base.IController, relativePath string) func(*gin.Context) {
+	route := this.findRoute(regController, relativePath)
+
+	return func(c *gin.Context) {
+		if route == nil || route.Handler == nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		controller, code, response := this.runController(regController, route, c)
+		controller.GetFormatter(c).Format(c, code, response)
+	}
+}
+
+// Controller and route of a method "/package.Service/Method", matched by the group of the
+// controller and the relative path of the route, case-insensitively.
+func (this *WebModule) findService(fullMethod string) (base.IController, *base.Route) {
+	i := strings.LastIndex(fullMethod, "/")
+
+	if i < 0 {
+		return nil, nil
+	}
+
+	service, method := strings.Trim(fullMethod[:i], "/"), fullMethod[i+1:]
+
+	if j := strings.LastIndex(service, "."); j >= 0 {
+		service = service[j+1:]
+	}
+
+	for _, controller := range this.controllers {
+		if !strings.EqualFold(controller.Group(), service) {
+			continue
+		}
+
+		for _, route := range controller.RoutesMap() {
+			if strings.EqualFold(strings.Trim(route.RelativePath, "/"), method) {
+				r := route
+				return controller, &r
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// Methods served by a controller, see findService, go through the same lifecycle as
+// RunAction with the handler in place of the action. Others only get the request id and
+// the trace id of the "x-trace-id" metadata on ctx.
+func (this *WebModule) RunService() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (resp interface{}, err error) {
+		requestID := NextRequestID()
+		req_start := time.Now().UnixNano() / int64(time.Millisecond)
+
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("[%v] %v panic %v", requestID, info.FullMethod, r)
+				err = status.Error(codes.Internal, "Unexpected error")
+			}
+
+			duration := time.Now().UnixNano()/int64(time.Millisecond) - req_start
+			logger.Profile("SERVICE|%v|%v|%v|%v", info.FullMethod, requestID, duration, status.Code(err))
+		}()
+
+		logger.Info("[%v] %v %v", requestID, info.FullMethod, req)
+		ctx = context.WithValue(ctx, base.KEY_REQUEST_ID, requestID)
+
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(base.HEADER_TRACE_ID)) > 0 {
+			ctx = context.WithValue(ctx, base.KEY_TRACE_ID, md.Get(base.HEADER_TRACE_ID)[0])
+		}
+
+		regController, route := this.findService(info.FullMethod)
+
+		if regController == nil {
+			return handler(ctx, req)
+		}
+
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = regController.ParseServiceRequest(strings.Trim(route.RelativePath, "/"), req).WithContext(ctx)
+		c.Set(base.KEY_REQUEST_ID, requestID)
+		c.Set(base.KEY_SERVICE, true)
+
+		if trace_id := base.TraceIDOf(ctx); trace_id != "" {
+			c.Set(base.KEY_TRACE_ID, trace_id)
+		}
+
+		handled := false
+		route.Handler = func(c *gin.Context) map[string]interface{} {
+			handled = true
+			resp, err = handler(base.RequestContext(c), req)
+
+			if err != nil {
+				st := status.Convert(err)
+
+				return map[string]interface{}{
+					regController.TitleRet():     int(st.Code()),
+					regController.TitleMessage(): st.Message(),
+				}
+			}
+
+			return map[string]interface{}{regController.TitleRet(): 0}
+		}
+
+		controller, _, response := this.runController(regController, route, c)
+
+		if ret, _ := response[controller.TitleRet()].(int); ret != 0 && (err == nil || !handled) {
+			message, _ := response[controller.TitleMessage()].(string)
+			err = status.Error(codes.Code(ret), message)
+		} else if !handled && err == nil {
+			err = status.Error(codes.Internal, "Unexpected error")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return resp, nil
+	}
+}
+
+func (this *WebModule) findJob(serviceName string, jobName string) (base.IController, *base.Route) {
+	var job_pre string
+
+	if serviceName != "" {
+		job_pre = "/" + serviceName
+	}
+
+	for _, controller := range this.controllers {
+		if !controller.SupportWorker() {
+			continue
+		}
+
+		controller_pre := job_pre + "/" + controller.Group() + "/"
+
+		for _, route := range controller.RoutesMap() {
+			if controller_pre+route.RelativePath == jobName {
+				r := route
+				return controller, &r
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func (this *WebModule) RunWorker(serviceName string) interface{} {
+	return func(job *work.Job) error {
+		controller, route := this.findJob(serviceName, job.Name)
+
+		if controller == nil || route.Handler == nil {
+			logger.Warning("Job %v not found", job.Name)
+			return errors.New("Job not found")
+		}
+
+		httpRequest := &http.Request{
+			Method:     "POST",
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     map[string][]string{"User-Agent": {"Worker Transfer"}},
+			Host:       "127.0.0.1:80",
+			RemoteAddr: "127.0.0.1:80",
+			URL:        &url.URL{Path: job.Name},
+			RequestURI: job.Name,
+			PostForm:   make(url.Values),
+		}
+
+		for key, value := range job.Args {
+			httpRequest.PostForm[key] = []string{fmt.Sprintf("%v", value)}
+		}
+
+		httpRequest.Form = httpRequest.PostForm
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httpRequest
+		_, _, response := this.runController(controller, route, c)
+		ret := c.GetInt(base.KEY_ACTION_RET)
+		logger.Notice("[%v] Job %v(%v) ret = %v", c.GetInt64(base.KEY_REQUEST_ID), job.Name, job.ID, ret)
+
+		if ret != 0 {
+			return fmt.Errorf("ret:%v, response:%v", ret, response)
+		}
+
+		return nil
+	}
+}
+
+func (this *WebModule) RunPoll() goiikafka.KafkaHandler {
+	return func(message *kafka.Message) error {
+		if this.PollHandler == nil {
+			logger.Warning("Kafka message %v unhandled", message.TopicPartition)
+			return nil
+		}
+
+		err := this.PollHandler(message)
+
+		if err != nil {
+			logger.Warning("Kafka message %v failed %v", message.TopicPartition, err.Error())
+		}
+
+		return err
+	}
+}
+
+func (this *WebModule) RunEther() ether.EtherHandler {
+	return func(topic string, body []byte) error {
+		if this.EtherHandler == nil {
+			logger.Warning("Ether %v unhandled", topic)
+			return nil
+		}
+
+		err := this.EtherHandler(topic, body)
+
+		if err != nil {
+			logger.Warning("Ether %v failed %v", topic, err.Error())
+		}
+
+		return err
+	}
+}
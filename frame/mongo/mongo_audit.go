@@ -0,0 +1,129 @@
+package mongo
+
+import (
+	"github.com/derekyu332/goii/helper/logger"
+	"github.com/globalsign/mgo/bson"
+	"time"
+)
+
+const (
+	AUDIT_COLLECTION = "col_audits"
+	AUDIT_INSERT     = "insert"
+	AUDIT_UPDATE     = "update"
+	AUDIT_UPSERT     = "upsert"
+	AUDIT_DELETE     = "delete"
+)
+
+type CollectionSoftDeleter interface {
+	SoftDeleteAttr() string
+}
+
+type CollectionAuditor interface {
+	AuditIgnore() []string
+}
+
+type AuditLog struct {
+	Id         bson.ObjectId          `bson:"_id"`
+	Collection string                 `bson:"collection"`
+	RecordId   interface{}            `bson:"record_id"`
+	Op         string                 `bson:"op"`
+	Old        map[string]interface{} `bson:"old,omitempty"`
+	New        map[string]interface{} `bson:"new,omitempty"`
+	RequestID  int64                  `bson:"request_id"`
+	Operator   string                 `bson:"operator"`
+	Created    time.Time              `bson:"created"`
+}
+
+func (this *AuditLog) GetId() interface{} {
+	return this.Id
+}
+
+func (this *AuditLog) TableName() string {
+	return AUDIT_COLLECTION
+}
+
+func (this *MongoModel) scope(cond bson.M) bson.M {
+	deleter, ok := this.Data.(CollectionSoftDeleter)
+
+	if !ok || this.WithDeleted {
+		return cond
+	}
+
+	alive := bson.M{deleter.SoftDeleteAttr(): bson.M{"$in": []interface{}{nil, time.Time{}}}}
+
+	if len(cond) == 0 {
+		return alive
+	}
+
+	return bson.M{"$and": []bson.M{cond, alive}}
+}
+
+func (this *MongoModel) auditLog(op string, before map[string]interface{}, after map[string]interface{}) *AuditLog {
+	auditor, ok := this.Data.(CollectionAuditor)
+
+	if !ok {
+		return nil
+	}
+
+	for _, name := range auditor.AuditIgnore() {
+		delete(before, name)
+		delete(after, name)
+	}
+
+	return &AuditLog{
+		Id:         bson.NewObjectId(),
+		Collection: this.Data.TableName(),
+		RecordId:   this.Data.GetId(),
+		Op:         op,
+		Old:        before,
+		New:        after,
+		RequestID:  this.RequestID,
+		Operator:   this.Operator,
+		Created:    time.Now(),
+	}
+}
+
+func (this *MongoModel) writeAudit(logs ...*AuditLog) {
+	var docs []interface{}
+
+	for _, log := range logs {
+		if log != nil {
+			docs = append(docs, log)
+		}
+	}
+
+	if len(docs) == 0 {
+		return
+	}
+
+	collection := this.collection(AUDIT_COLLECTION)
+	defer collection.Close()
+
+	if err := collection.Insert(docs...); err != nil {
+		logger.Warning("[%v] Audit %v records failed %v", this.LogID(), len(docs), err.Error())
+	}
+}
+
+func auditOld(oldAttr map[string]interface{}, dirty_attr map[string]interface{}) map[string]interface{} {
+	old := make(map[string]interface{})
+
+	for name := range dirty_attr {
+		if value, ok := oldAttr[name]; ok {
+			old[name] = value
+		}
+	}
+
+	return old
+}
+
+func auditCopy(attr map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{})
+
+	for name, value := range attr {
+		if name != "_id" {
+			copied[name] = value
+		}
+	}
+
+	return copied
+}
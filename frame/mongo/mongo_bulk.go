@@ -0,0 +1,258 @@
+package mongo
+
+import (
+	"errors"
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/frame/cache"
+	"github.com/derekyu332/goii/helper/extend"
+	"github.com/derekyu332/goii/helper/logger"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"time"
+)
+
+var (
+	ErrStaleRecord = errors.New("Stale record")
+)
+
+type BulkResult struct {
+	Id  interface{}
+	Err error
+}
+
+// Models must belong to the collection of this.Data. Updates of CollectionLocker records run
+// one by one after the batch, an update matching no document reports ErrStaleRecord.
+func (this *MongoModel) BulkSave(models []*MongoModel) ([]BulkResult, error) {
+	results := make([]BulkResult, len(models))
+	var ops []bulkOp
+	var index []int
+	var logs []*AuditLog
+	var lock_ops []bulkOp
+	var locked []int
+	dirty := make(map[int]map[string]interface{})
+
+	for i, model := range models {
+		results[i].Id = model.Data.GetId()
+		err := base.RunBeforeValidate(model.Data)
+
+		if err == nil {
+			err = model.Validate()
+		}
+
+		if err == nil {
+			err = base.RunBeforeSave(model.Data, !model.Exists)
+		}
+
+		if err != nil {
+			logger.Warning("[%v] BeforeSave %v failed %v", this.LogID(), results[i].Id, err.Error())
+			results[i].Err = err
+			continue
+		}
+
+		if !model.Exists {
+			ops = append(ops, bulkOp{kind: BULK_INSERT, doc: model.Data})
+			index = append(index, i)
+			continue
+		}
+
+		if tracker, ok := model.Data.(CollectionTimeTracker); ok {
+			tracker.SetModified(time.Now())
+		}
+
+		dirty_attr := model.GetDirtyAttr()
+
+		if len(dirty_attr) <= 0 {
+			continue
+		}
+
+		dirty[i] = dirty_attr
+
+		if locker, ok := model.Data.(CollectionLocker); ok {
+			lock_name := locker.OptimisticLock()
+			lock_seq, _ := extend.InterfaceToInt64(model.oldAttr[lock_name])
+			lock_seq += model.update_seq
+			lock_ops = append(lock_ops, bulkOp{
+				kind: BULK_UPDATE,
+				cond: bson.D{{Name: "_id", Value: model.Data.GetId()}, {Name: lock_name, Value: lock_seq}},
+				doc:  bson.M{"$set": dirty_attr, "$inc": bson.M{lock_name: 1}},
+			})
+			locked = append(locked, i)
+			continue
+		}
+
+		ops = append(ops, bulkOp{
+			kind: BULK_UPDATE,
+			cond: bson.M{"_id": model.Data.GetId()},
+			doc:  bson.M{"$set": dirty_attr},
+		})
+		index = append(index, i)
+	}
+
+	if len(ops) == 0 && len(locked) == 0 {
+		return results, nil
+	}
+
+	req_start := time.Now().UnixNano() / int64(time.Millisecond)
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
+	errs, err := collection.Bulk(ops)
+
+	for k, i := range index {
+		if err != nil {
+			results[i].Err = err
+		} else if errs[k] != nil {
+			results[i].Err = errs[k]
+		}
+	}
+
+	for k, i := range locked {
+		if e := collection.Update(lock_ops[k].cond, lock_ops[k].doc); e == mgo.ErrNotFound {
+			logger.Warning("[%v] Update %v stale", this.LogID(), models[i].Data.GetId())
+			results[i].Err = ErrStaleRecord
+		} else if e != nil {
+			results[i].Err = e
+		}
+	}
+
+	for _, i := range append(index, locked...) {
+		model := models[i]
+
+		if results[i].Err != nil {
+			logger.Warning("[%v] BulkSave %v failed %v", this.LogID(), results[i].Id, results[i].Err.Error())
+			continue
+		}
+
+		insert := !model.Exists
+
+		if insert {
+			model.Exists = true
+			model.RefreshOldAttr()
+			logs = append(logs, model.auditLog(AUDIT_INSERT, nil, auditCopy(model.oldAttr)))
+		} else {
+			logs = append(logs, model.auditLog(AUDIT_UPDATE, auditOld(model.oldAttr, dirty[i]), auditCopy(dirty[i])))
+			new_update_seq := model.update_seq + 1
+			model.RefreshOldAttr()
+			model.update_seq = new_update_seq
+		}
+
+		results[i].Err = base.RunAfterSave(model.Data, insert)
+	}
+
+	records := make([]base.IActiveRecord, len(models))
+
+	for i, model := range models {
+		records[i] = model.Data
+	}
+
+	cache.InvalidateRecords(this.Context(), records)
+	this.writeAudit(logs...)
+
+	if err == nil {
+		logger.Notice("[%v] BulkSave %v records success", this.LogID(), len(ops)+len(locked))
+	}
+
+	return results, this.LOG_RET_ERR(this.Data.TableName(), req_start, "BulkSave", bson.M{"count": len(ops) + len(locked)}, err)
+}
+
+func (this *MongoModel) BulkUpsert(records []base.IActiveRecord) ([]BulkResult, error) {
+	ops := make([]bulkOp, len(records))
+
+	for i, record := range records {
+		ops[i] = bulkOp{kind: BULK_UPSERT, cond: bson.M{"_id": record.GetId()}, doc: record}
+	}
+
+	return this.bulk("BulkUpsert", AUDIT_UPSERT, records, ops)
+}
+
+func (this *MongoModel) BulkDelete(records []base.IActiveRecord) ([]BulkResult, error) {
+	results := make([]BulkResult, len(records))
+	var passed []base.IActiveRecord
+	var ops []bulkOp
+	var index []int
+	deleter, soft := this.Data.(CollectionSoftDeleter)
+
+	for i, record := range records {
+		results[i].Id = record.GetId()
+
+		if err := base.RunBeforeDelete(record); err != nil {
+			logger.Warning("[%v] BeforeDelete %v failed %v", this.LogID(), results[i].Id, err.Error())
+			results[i].Err = err
+			continue
+		}
+
+		if soft {
+			ops = append(ops, bulkOp{
+				kind: BULK_UPDATE,
+				cond: bson.M{"_id": record.GetId()},
+				doc:  bson.M{"$set": bson.M{deleter.SoftDeleteAttr(): time.Now()}},
+			})
+		} else {
+			ops = append(ops, bulkOp{kind: BULK_REMOVE, cond: bson.M{"_id": record.GetId()}})
+		}
+
+		passed = append(passed, record)
+		index = append(index, i)
+	}
+
+	deleted, err := this.bulk("BulkDelete", AUDIT_DELETE, passed, ops)
+
+	for k, i := range index {
+		results[i] = deleted[k]
+
+		if results[i].Err == nil {
+			results[i].Err = base.RunAfterDelete(records[i])
+		}
+	}
+
+	return results, err
+}
+
+func (this *MongoModel) bulk(op string, audit string, records []base.IActiveRecord, ops []bulkOp) ([]BulkResult, error) {
+	results := make([]BulkResult, len(records))
+
+	if len(ops) == 0 {
+		return results, nil
+	}
+
+	req_start := time.Now().UnixNano() / int64(time.Millisecond)
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
+	errs, err := collection.Bulk(ops)
+	var logs []*AuditLog
+	cache.InvalidateRecords(this.Context(), records)
+
+	for i, record := range records {
+		results[i].Id = record.GetId()
+
+		if err != nil {
+			results[i].Err = err
+		} else {
+			results[i].Err = errs[i]
+		}
+
+		if results[i].Err != nil {
+			logger.Warning("[%v] %v %v failed %v", this.LogID(), op, results[i].Id, results[i].Err.Error())
+			continue
+		}
+
+		model := &MongoModel{}
+		model.Data = record
+		model.RequestID = this.RequestID
+		model.Operator = this.Operator
+		attr := auditCopy(base.StructToMap(record, "bson"))
+
+		if audit == AUDIT_DELETE {
+			logs = append(logs, model.auditLog(audit, attr, nil))
+		} else {
+			logs = append(logs, model.auditLog(audit, nil, attr))
+		}
+	}
+
+	this.writeAudit(logs...)
+
+	if err == nil {
+		logger.Notice("[%v] %v %v records success", this.LogID(), op, len(ops))
+	}
+
+	return results, this.LOG_RET_ERR(this.Data.TableName(), req_start, op, bson.M{"count": len(ops)}, err)
+}
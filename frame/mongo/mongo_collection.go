@@ -0,0 +1,350 @@
+package mongo
+
+import (
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"time"
+)
+
+const (
+	BULK_INSERT = iota
+	BULK_UPDATE
+	BULK_UPSERT
+	BULK_REMOVE
+)
+
+type bulkOp struct {
+	kind int
+	cond interface{}
+	doc  interface{}
+}
+
+type mongoIter interface {
+	Next(result interface{}) bool
+	Err() error
+	Close() error
+}
+
+type mongoCollection interface {
+	Count(cond interface{}) (int, error)
+	Pipe(pipeline interface{}, result interface{}) error
+	FindOne(cond interface{}, result interface{}) error
+	FindAll(cond interface{}, sort []string, selector bson.M, skip int, limit int, result interface{}) error
+	Iter(cond interface{}, sort []string, selector bson.M, skip int, limit int) (mongoIter, error)
+	Insert(docs ...interface{}) error
+	Update(cond interface{}, update interface{}) error
+	UpdateId(id interface{}, update interface{}) error
+	UpdateAll(cond interface{}, update interface{}) (int, error)
+	Upsert(cond interface{}, update interface{}) error
+	RemoveId(id interface{}) error
+	RemoveAll(cond interface{}) (int, error)
+	Apply(cond interface{}, update interface{}, upsert bool, returnNew bool, result interface{}) (int, error)
+	Bulk(ops []bulkOp) ([]error, error)
+	EnsureIndex(index mgo.Index) error
+	Watch(pipeline interface{}, resumeAfter bson.M, maxAwait time.Duration) (changeStream, error)
+	Close()
+}
+
+type mgoCollection struct {
+	session    *mgo.Session
+	collection *mgo.Collection
+}
+
+func newMgoCollection(session *mgo.Session, dbName string, colName string) *mgoCollection {
+	return &mgoCollection{
+		session:    session,
+		collection: session.DB(dbName).C(colName),
+	}
+}
+
+func (this *mgoCollection) Count(cond interface{}) (int, error) {
+	return this.collection.Find(cond).Count()
+}
+
+func (this *mgoCollection) Pipe(pipeline interface{}, result interface{}) error {
+	return this.collection.Pipe(pipeline).All(result)
+}
+
+func (this *mgoCollection) FindOne(cond interface{}, result interface{}) error {
+	return this.collection.Find(cond).One(result)
+}
+
+func (this *mgoCollection) FindAll(cond interface{}, sort []string, selector bson.M, skip int, limit int,
+	result interface{}) error {
+	return this.find(cond, sort, selector, skip, limit).All(result)
+}
+
+func (this *mgoCollection) Iter(cond interface{}, sort []string, selector bson.M, skip int, limit int) (mongoIter, error) {
+	return this.find(cond, sort, selector, skip, limit).Iter(), nil
+}
+
+func (this *mgoCollection) find(cond interface{}, sort []string, selector bson.M, skip int, limit int) *mgo.Query {
+	query := this.collection.Find(cond).Sort(sort...)
+
+	if selector != nil {
+		query = query.Select(selector)
+	}
+
+	if skip > 0 {
+		query = query.Skip(skip)
+	}
+
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	return query
+}
+
+func (this *mgoCollection) Insert(docs ...interface{}) error {
+	return this.collection.Insert(docs...)
+}
+
+func (this *mgoCollection) Update(cond interface{}, update interface{}) error {
+	return this.collection.Update(cond, update)
+}
+
+func (this *mgoCollection) UpdateId(id interface{}, update interface{}) error {
+	return this.collection.UpdateId(id, update)
+}
+
+func (this *mgoCollection) UpdateAll(cond interface{}, update interface{}) (int, error) {
+	info, err := this.collection.UpdateAll(cond, update)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Updated, nil
+}
+
+func (this *mgoCollection) Upsert(cond interface{}, update interface{}) error {
+	_, err := this.collection.Upsert(cond, update)
+
+	return err
+}
+
+func (this *mgoCollection) RemoveId(id interface{}) error {
+	return this.collection.RemoveId(id)
+}
+
+func (this *mgoCollection) RemoveAll(cond interface{}) (int, error) {
+	info, err := this.collection.RemoveAll(cond)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Removed, nil
+}
+
+func (this *mgoCollection) Apply(cond interface{}, update interface{}, upsert bool, returnNew bool,
+	result interface{}) (int, error) {
+	info, err := this.collection.Find(cond).Apply(mgo.Change{
+		Update:    update,
+		Upsert:    upsert,
+		ReturnNew: returnNew,
+	}, result)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Updated, nil
+}
+
+func (this *mgoCollection) Bulk(ops []bulkOp) ([]error, error) {
+	errs := make([]error, len(ops))
+
+	if len(ops) == 0 {
+		return errs, nil
+	}
+
+	bulk := this.collection.Bulk()
+	bulk.Unordered()
+
+	for _, op := range ops {
+		switch op.kind {
+		case BULK_INSERT:
+			bulk.Insert(op.doc)
+		case BULK_UPDATE:
+			bulk.Update(op.cond, op.doc)
+		case BULK_UPSERT:
+			bulk.Upsert(op.cond, op.doc)
+		case BULK_REMOVE:
+			bulk.Remove(op.cond)
+		}
+	}
+
+	_, err := bulk.Run()
+
+	if bulk_err, ok := err.(*mgo.BulkError); ok {
+		for _, c := range bulk_err.Cases() {
+			if c.Index < 0 || c.Index >= len(errs) {
+				return errs, err
+			}
+
+			errs[c.Index] = c.Err
+		}
+
+		return errs, nil
+	}
+
+	return errs, err
+}
+
+func (this *mgoCollection) EnsureIndex(index mgo.Index) error {
+	return this.collection.EnsureIndex(index)
+}
+
+func (this *mgoCollection) Watch(pipeline interface{}, resumeAfter bson.M, maxAwait time.Duration) (changeStream, error) {
+	opts := mgo.ChangeStreamOptions{
+		FullDocument:   mgo.UpdateLookup,
+		MaxAwaitTimeMS: maxAwait,
+	}
+
+	if resumeAfter != nil {
+		data, err := bson.Marshal(resumeAfter)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts.ResumeAfter = &bson.Raw{Kind: 0x03, Data: data}
+	}
+
+	stream, err := this.collection.Watch(pipeline, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &mgoStream{stream: stream}, nil
+}
+
+func (this *mgoCollection) Close() {
+	this.session.Close()
+}
+
+type mgoStream struct {
+	stream *mgo.ChangeStream
+}
+
+func (this *mgoStream) Next(event *ChangeEvent) bool {
+	raw := struct {
+		OperationType     string             `bson:"operationType"`
+		DocumentKey       bson.M             `bson:"documentKey"`
+		UpdateDescription *UpdateDescription `bson:"updateDescription"`
+		FullDocument      *bson.Raw          `bson:"fullDocument"`
+	}{}
+
+	if !this.stream.Next(&raw) {
+		return false
+	}
+
+	event.OperationType = raw.OperationType
+	event.DocumentKey = raw.DocumentKey
+	event.UpdateDescription = raw.UpdateDescription
+
+	if doc := raw.FullDocument; doc != nil && doc.Kind == 0x03 {
+		doc.Unmarshal(&event.FullDocument)
+		event.decode = doc.Unmarshal
+	}
+
+	return true
+}
+
+func (this *mgoStream) ResumeToken() bson.M {
+	token := this.stream.ResumeToken()
+
+	if token == nil {
+		return nil
+	}
+
+	var doc bson.M
+
+	if err := token.Unmarshal(&doc); err != nil {
+		return nil
+	}
+
+	return doc
+}
+
+func (this *mgoStream) Err() error {
+	return this.stream.Err()
+}
+
+func (this *mgoStream) Close() error {
+	return this.stream.Close()
+}
+
+type errCollection struct {
+	err error
+}
+
+func (this *errCollection) Count(interface{}) (int, error) {
+	return 0, this.err
+}
+
+func (this *errCollection) Pipe(interface{}, interface{}) error {
+	return this.err
+}
+
+func (this *errCollection) FindOne(interface{}, interface{}) error {
+	return this.err
+}
+
+func (this *errCollection) FindAll(interface{}, []string, bson.M, int, int, interface{}) error {
+	return this.err
+}
+
+func (this *errCollection) Iter(interface{}, []string, bson.M, int, int) (mongoIter, error) {
+	return nil, this.err
+}
+
+func (this *errCollection) Insert(...interface{}) error {
+	return this.err
+}
+
+func (this *errCollection) Update(interface{}, interface{}) error {
+	return this.err
+}
+
+func (this *errCollection) UpdateId(interface{}, interface{}) error {
+	return this.err
+}
+
+func (this *errCollection) UpdateAll(interface{}, interface{}) (int, error) {
+	return 0, this.err
+}
+
+func (this *errCollection) Upsert(interface{}, interface{}) error {
+	return this.err
+}
+
+func (this *errCollection) RemoveId(interface{}) error {
+	return this.err
+}
+
+func (this *errCollection) RemoveAll(interface{}) (int, error) {
+	return 0, this.err
+}
+
+func (this *errCollection) Apply(interface{}, interface{}, bool, bool, interface{}) (int, error) {
+	return 0, this.err
+}
+
+func (this *errCollection) Bulk([]bulkOp) ([]error, error) {
+	return nil, this.err
+}
+
+func (this *errCollection) EnsureIndex(mgo.Index) error {
+	return this.err
+}
+
+func (this *errCollection) Watch(interface{}, bson.M, time.Duration) (changeStream, error) {
+	return nil, this.err
+}
+
+func (this *errCollection) Close() {
+}
@@ -0,0 +1,614 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/derekyu332/goii/helper/logger"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	DRIVER_MGO                = "mgo"
+	DRIVER_OFFICIAL           = "official"
+	DRIVER_CONNECT_TIME_OUT   = 3 * time.Second
+	DRIVER_OPERATION_TIME_OUT = 10 * time.Second
+)
+
+var (
+	gRegistry = newRegistry()
+)
+
+func newRegistry() *bsoncodec.Registry {
+	registry := driverbson.NewRegistry()
+	objectIdType := reflect.TypeOf(bson.ObjectId(""))
+	registry.RegisterTypeEncoder(objectIdType, bsoncodec.ValueEncoderFunc(encodeObjectId))
+	registry.RegisterTypeDecoder(objectIdType, bsoncodec.ValueDecoderFunc(decodeObjectId))
+	registry.RegisterTypeEncoder(reflect.TypeOf(bson.D{}), bsoncodec.ValueEncoderFunc(encodeDoc))
+
+	return registry
+}
+
+func encodeObjectId(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	id := val.Interface().(bson.ObjectId)
+
+	if id == "" {
+		return vw.WriteNull()
+	}
+
+	if !id.Valid() {
+		return fmt.Errorf("invalid ObjectId %q", string(id))
+	}
+
+	var oid primitive.ObjectID
+	copy(oid[:], id)
+
+	return vw.WriteObjectID(oid)
+}
+
+func decodeObjectId(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	switch vr.Type() {
+	case bsontype.ObjectID:
+		oid, err := vr.ReadObjectID()
+
+		if err != nil {
+			return err
+		}
+
+		val.SetString(string(oid[:]))
+	case bsontype.Null:
+		val.SetString("")
+
+		return vr.ReadNull()
+	default:
+		return fmt.Errorf("cannot decode %v into ObjectId", vr.Type())
+	}
+
+	return nil
+}
+
+func encodeDoc(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	dw, err := vw.WriteDocument()
+
+	if err != nil {
+		return err
+	}
+
+	for _, elem := range val.Interface().(bson.D) {
+		evw, err := dw.WriteDocumentElement(elem.Name)
+
+		if err != nil {
+			return err
+		}
+
+		if elem.Value == nil {
+			if err = evw.WriteNull(); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		encoder, err := ec.LookupEncoder(reflect.TypeOf(elem.Value))
+
+		if err != nil {
+			return err
+		}
+
+		if err = encoder.EncodeValue(ec, evw, reflect.ValueOf(elem.Value)); err != nil {
+			return err
+		}
+	}
+
+	return dw.WriteDocumentEnd()
+}
+
+func InitNamedDriverConnection(name string, uri string, dbName string) error {
+	logger.Warning("Try Connect %v...", name)
+	ctx, cancel := context.WithTimeout(context.Background(), DRIVER_CONNECT_TIME_OUT)
+	defer cancel()
+	client, err := driver.Connect(ctx, options.Client().ApplyURI(uri).SetRegistry(gRegistry).
+		SetConnectTimeout(DRIVER_CONNECT_TIME_OUT).SetServerSelectionTimeout(DRIVER_CONNECT_TIME_OUT).
+		SetTimeout(DRIVER_OPERATION_TIME_OUT))
+
+	if err != nil {
+		return err
+	}
+
+	if err = client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return err
+	}
+
+	gConnectionLock.Lock()
+	defer gConnectionLock.Unlock()
+
+	if old, ok := gConnections[name]; ok {
+		old.close()
+	}
+
+	gConnections[name] = &mongoConnection{
+		dbName: dbName,
+		client: client,
+	}
+	logger.Warning("Connect %v Success", name)
+
+	return nil
+}
+
+func driverError(err error) error {
+	if err == driver.ErrNoDocuments {
+		return mgo.ErrNotFound
+	}
+
+	if driver.IsDuplicateKeyError(err) {
+		return &mgo.LastError{Code: 11000, Err: err.Error()}
+	}
+
+	return err
+}
+
+func driverFilter(cond interface{}) interface{} {
+	v := reflect.ValueOf(cond)
+
+	if !v.IsValid() || (v.Kind() == reflect.Map && v.IsNil()) {
+		return driverbson.D{}
+	}
+
+	return cond
+}
+
+func driverSort(sort []string) driverbson.D {
+	doc := driverbson.D{}
+
+	for _, field := range sort {
+		field = strings.TrimSpace(field)
+
+		if field == "" {
+			continue
+		}
+
+		order := 1
+
+		if strings.HasPrefix(field, "-") {
+			order = -1
+			field = field[1:]
+		} else if strings.HasPrefix(field, "+") {
+			field = field[1:]
+		}
+
+		doc = append(doc, driverbson.E{Key: field, Value: order})
+	}
+
+	return doc
+}
+
+func isOperatorDoc(update interface{}) bool {
+	switch doc := update.(type) {
+	case bson.M:
+		for key := range doc {
+			return strings.HasPrefix(key, "$")
+		}
+	case map[string]interface{}:
+		for key := range doc {
+			return strings.HasPrefix(key, "$")
+		}
+	case bson.D:
+		return len(doc) > 0 && strings.HasPrefix(doc[0].Name, "$")
+	case driverbson.D:
+		return len(doc) > 0 && strings.HasPrefix(doc[0].Key, "$")
+	}
+
+	return false
+}
+
+type driverCollection struct {
+	ctx        context.Context
+	collection *driver.Collection
+}
+
+func newDriverCollection(ctx context.Context, client *driver.Client, dbName string, colName string) *driverCollection {
+	return &driverCollection{
+		ctx:        ctx,
+		collection: client.Database(dbName).Collection(colName),
+	}
+}
+
+func (this *driverCollection) Count(cond interface{}) (int, error) {
+	n, err := this.collection.CountDocuments(this.ctx, driverFilter(cond))
+
+	return int(n), driverError(err)
+}
+
+func (this *driverCollection) Pipe(pipeline interface{}, result interface{}) error {
+	cursor, err := this.collection.Aggregate(this.ctx, pipeline)
+
+	if err != nil {
+		return driverError(err)
+	}
+
+	return driverError(cursor.All(this.ctx, result))
+}
+
+func (this *driverCollection) FindOne(cond interface{}, result interface{}) error {
+	return driverError(this.collection.FindOne(this.ctx, driverFilter(cond)).Decode(result))
+}
+
+func (this *driverCollection) FindAll(cond interface{}, sort []string, selector bson.M, skip int, limit int,
+	result interface{}) error {
+	cursor, err := this.collection.Find(this.ctx, driverFilter(cond), findOptions(sort, selector, skip, limit))
+
+	if err != nil {
+		return driverError(err)
+	}
+
+	return driverError(cursor.All(this.ctx, result))
+}
+
+func (this *driverCollection) Iter(cond interface{}, sort []string, selector bson.M, skip int, limit int) (mongoIter, error) {
+	cursor, err := this.collection.Find(this.ctx, driverFilter(cond), findOptions(sort, selector, skip, limit))
+
+	if err != nil {
+		return nil, driverError(err)
+	}
+
+	return &driverIter{ctx: this.ctx, cursor: cursor}, nil
+}
+
+func findOptions(sort []string, selector bson.M, skip int, limit int) *options.FindOptions {
+	opts := options.Find()
+
+	if len(sort) > 0 {
+		opts.SetSort(driverSort(sort))
+	}
+
+	if selector != nil {
+		opts.SetProjection(selector)
+	}
+
+	if skip > 0 {
+		opts.SetSkip(int64(skip))
+	}
+
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+
+	return opts
+}
+
+func (this *driverCollection) Insert(docs ...interface{}) error {
+	var err error
+
+	if len(docs) == 1 {
+		_, err = this.collection.InsertOne(this.ctx, docs[0])
+	} else {
+		_, err = this.collection.InsertMany(this.ctx, docs)
+	}
+
+	return driverError(err)
+}
+
+func (this *driverCollection) Update(cond interface{}, update interface{}) error {
+	var result *driver.UpdateResult
+	var err error
+
+	if isOperatorDoc(update) {
+		result, err = this.collection.UpdateOne(this.ctx, driverFilter(cond), update)
+	} else {
+		result, err = this.collection.ReplaceOne(this.ctx, driverFilter(cond), update)
+	}
+
+	if err != nil {
+		return driverError(err)
+	}
+
+	if result.MatchedCount == 0 {
+		return mgo.ErrNotFound
+	}
+
+	return nil
+}
+
+func (this *driverCollection) UpdateId(id interface{}, update interface{}) error {
+	return this.Update(bson.M{"_id": id}, update)
+}
+
+func (this *driverCollection) UpdateAll(cond interface{}, update interface{}) (int, error) {
+	result, err := this.collection.UpdateMany(this.ctx, driverFilter(cond), update)
+
+	if err != nil {
+		return 0, driverError(err)
+	}
+
+	return int(result.MatchedCount), nil
+}
+
+func (this *driverCollection) Upsert(cond interface{}, update interface{}) error {
+	var err error
+
+	if isOperatorDoc(update) {
+		_, err = this.collection.UpdateOne(this.ctx, driverFilter(cond), update, options.Update().SetUpsert(true))
+	} else {
+		_, err = this.collection.ReplaceOne(this.ctx, driverFilter(cond), update, options.Replace().SetUpsert(true))
+	}
+
+	return driverError(err)
+}
+
+func (this *driverCollection) RemoveId(id interface{}) error {
+	result, err := this.collection.DeleteOne(this.ctx, bson.M{"_id": id})
+
+	if err != nil {
+		return driverError(err)
+	}
+
+	if result.DeletedCount == 0 {
+		return mgo.ErrNotFound
+	}
+
+	return nil
+}
+
+func (this *driverCollection) RemoveAll(cond interface{}) (int, error) {
+	result, err := this.collection.DeleteMany(this.ctx, driverFilter(cond))
+
+	if err != nil {
+		return 0, driverError(err)
+	}
+
+	return int(result.DeletedCount), nil
+}
+
+func (this *driverCollection) Apply(cond interface{}, update interface{}, upsert bool, returnNew bool,
+	result interface{}) (int, error) {
+	returnDocument := options.Before
+
+	if returnNew {
+		returnDocument = options.After
+	}
+
+	var single *driver.SingleResult
+
+	if isOperatorDoc(update) {
+		single = this.collection.FindOneAndUpdate(this.ctx, driverFilter(cond), update,
+			options.FindOneAndUpdate().SetUpsert(upsert).SetReturnDocument(returnDocument))
+	} else {
+		single = this.collection.FindOneAndReplace(this.ctx, driverFilter(cond), update,
+			options.FindOneAndReplace().SetUpsert(upsert).SetReturnDocument(returnDocument))
+	}
+
+	if err := single.Decode(result); err != nil {
+		return 0, driverError(err)
+	}
+
+	return 1, nil
+}
+
+func (this *driverCollection) Bulk(ops []bulkOp) ([]error, error) {
+	errs := make([]error, len(ops))
+
+	if len(ops) == 0 {
+		return errs, nil
+	}
+
+	models := make([]driver.WriteModel, 0, len(ops))
+
+	for _, op := range ops {
+		switch op.kind {
+		case BULK_INSERT:
+			models = append(models, driver.NewInsertOneModel().SetDocument(op.doc))
+		case BULK_UPDATE, BULK_UPSERT:
+			upsert := op.kind == BULK_UPSERT
+
+			if isOperatorDoc(op.doc) {
+				models = append(models, driver.NewUpdateOneModel().SetFilter(driverFilter(op.cond)).
+					SetUpdate(op.doc).SetUpsert(upsert))
+			} else {
+				models = append(models, driver.NewReplaceOneModel().SetFilter(driverFilter(op.cond)).
+					SetReplacement(op.doc).SetUpsert(upsert))
+			}
+		case BULK_REMOVE:
+			models = append(models, driver.NewDeleteOneModel().SetFilter(driverFilter(op.cond)))
+		}
+	}
+
+	_, err := this.collection.BulkWrite(this.ctx, models, options.BulkWrite().SetOrdered(false))
+
+	if bulk_err, ok := err.(driver.BulkWriteException); ok && bulk_err.WriteConcernError == nil {
+		for _, e := range bulk_err.WriteErrors {
+			if e.Index < 0 || e.Index >= len(errs) {
+				return errs, err
+			}
+
+			if e.Code == 11000 {
+				errs[e.Index] = &mgo.LastError{Code: 11000, Err: e.Message}
+			} else {
+				errs[e.Index] = errors.New(e.Message)
+			}
+		}
+
+		return errs, nil
+	}
+
+	return errs, driverError(err)
+}
+
+func (this *driverCollection) EnsureIndex(index mgo.Index) error {
+	keys := driverbson.D{}
+
+	for _, key := range index.Key {
+		var value interface{} = 1
+
+		switch {
+		case strings.HasPrefix(key, "-"):
+			key, value = key[1:], -1
+		case strings.HasPrefix(key, "$text:"):
+			key, value = key[6:], "text"
+		case strings.HasPrefix(key, "$2dsphere:"):
+			key, value = key[10:], "2dsphere"
+		case strings.HasPrefix(key, "$2d:"):
+			key, value = key[4:], "2d"
+		case strings.HasPrefix(key, "$hashed:"):
+			key, value = key[8:], "hashed"
+		}
+
+		keys = append(keys, driverbson.E{Key: key, Value: value})
+	}
+
+	opts := options.Index()
+
+	if index.Name != "" {
+		opts.SetName(index.Name)
+	}
+
+	if index.Unique {
+		opts.SetUnique(true)
+	}
+
+	if index.Sparse {
+		opts.SetSparse(true)
+	}
+
+	if index.ExpireAfter > 0 {
+		opts.SetExpireAfterSeconds(int32(index.ExpireAfter / time.Second))
+	}
+
+	if index.PartialFilter != nil {
+		opts.SetPartialFilterExpression(index.PartialFilter)
+	}
+
+	_, err := this.collection.Indexes().CreateOne(this.ctx, driver.IndexModel{Keys: keys, Options: opts})
+
+	return driverError(err)
+}
+
+func (this *driverCollection) Watch(pipeline interface{}, resumeAfter bson.M, maxAwait time.Duration) (changeStream, error) {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup).SetMaxAwaitTime(maxAwait)
+
+	if resumeAfter != nil {
+		opts.SetResumeAfter(resumeAfter)
+	}
+
+	stream, err := this.collection.Watch(this.ctx, pipeline, opts)
+
+	if err != nil {
+		return nil, driverError(err)
+	}
+
+	return &driverStream{ctx: this.ctx, stream: stream}, nil
+}
+
+func (this *driverCollection) Close() {
+}
+
+type driverIter struct {
+	ctx    context.Context
+	cursor *driver.Cursor
+	err    error
+}
+
+func (this *driverIter) Next(result interface{}) bool {
+	if this.err != nil || !this.cursor.Next(this.ctx) {
+		return false
+	}
+
+	if err := this.cursor.Decode(result); err != nil {
+		this.err = err
+		return false
+	}
+
+	return true
+}
+
+func (this *driverIter) Err() error {
+	if this.err != nil {
+		return this.err
+	}
+
+	return driverError(this.cursor.Err())
+}
+
+func (this *driverIter) Close() error {
+	return driverError(this.cursor.Close(this.ctx))
+}
+
+type driverStream struct {
+	ctx    context.Context
+	stream *driver.ChangeStream
+	err    error
+}
+
+func (this *driverStream) Next(event *ChangeEvent) bool {
+	if !this.stream.TryNext(this.ctx) {
+		return false
+	}
+
+	raw := struct {
+		OperationType     string             `bson:"operationType"`
+		DocumentKey       bson.M             `bson:"documentKey"`
+		UpdateDescription *UpdateDescription `bson:"updateDescription"`
+		FullDocument      driverbson.Raw     `bson:"fullDocument"`
+	}{}
+
+	if err := this.stream.Decode(&raw); err != nil {
+		this.err = err
+		return false
+	}
+
+	event.OperationType = raw.OperationType
+	event.DocumentKey = raw.DocumentKey
+	event.UpdateDescription = raw.UpdateDescription
+
+	if oid, ok := event.DocumentKey["_id"].(primitive.ObjectID); ok {
+		event.DocumentKey["_id"] = bson.ObjectId(oid[:])
+	}
+
+	if doc := raw.FullDocument; len(doc) > 0 {
+		driverbson.UnmarshalWithRegistry(gRegistry, doc, &event.FullDocument)
+		event.decode = func(record interface{}) error {
+			return driverbson.UnmarshalWithRegistry(gRegistry, doc, record)
+		}
+	}
+
+	return true
+}
+
+func (this *driverStream) ResumeToken() bson.M {
+	token := this.stream.ResumeToken()
+
+	if token == nil {
+		return nil
+	}
+
+	var doc bson.M
+
+	if err := driverbson.Unmarshal(token, &doc); err != nil {
+		return nil
+	}
+
+	return doc
+}
+
+func (this *driverStream) Err() error {
+	if this.err != nil {
+		return this.err
+	}
+
+	return driverError(this.stream.Err())
+}
+
+func (this *driverStream) Close() error {
+	return this.stream.Close(context.Background())
+}
@@ -0,0 +1,210 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/helper/logger"
+	"github.com/globalsign/mgo/bson"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type MongoIterator struct {
+	ctx        context.Context
+	model      *MongoModel
+	cond       bson.M
+	collection mongoCollection
+	iter       mongoIter
+	current    *MongoModel
+	count      int
+	err        error
+	closed     bool
+	req_start  int64
+}
+
+// Cancellation of ctx is checked between records.
+func (this *MongoModel) Iterate(ctx context.Context, selector FindAllSelector) (*MongoIterator, error) {
+	selector, err := this.compileSelector(selector)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if selector.Cond == nil {
+		return nil, errors.New("selector.Cond == nil")
+	}
+
+	selector.Cond = this.scope(selector.Cond)
+
+	if ctx == nil {
+		ctx = this.Context()
+	}
+
+	req_start := time.Now().UnixNano() / int64(time.Millisecond)
+	collection := contextCollection(ctx, this.connectionName(), this.Data.TableName(), this.tx)
+	var sort string
+
+	if selector.Sort == "" {
+		sort = "_id"
+	} else {
+		sort = selector.Sort
+	}
+
+	var iter mongoIter
+
+	if selector.Page < 0 || selector.Limit <= 0 {
+		iter, err = collection.Iter(selector.Cond, strings.Split(sort, ","), selector.Col, 0, 0)
+	} else {
+		iter, err = collection.Iter(selector.Cond, strings.Split(sort, ","), selector.Col,
+			selector.Page*selector.Limit, selector.Limit)
+	}
+
+	if err != nil {
+		collection.Close()
+		logger.Error("[%v] Iterate %v failed %v", this.LogID(), selector.Cond, err)
+
+		return nil, this.LOG_RET_ERR(this.Data.TableName(), req_start, "Iterate", selector.Cond, err)
+	}
+
+	return &MongoIterator{
+		ctx:        ctx,
+		model:      this,
+		cond:       selector.Cond,
+		collection: collection,
+		iter:       iter,
+		req_start:  req_start,
+	}, nil
+}
+
+func (this *MongoModel) Each(ctx context.Context, selector FindAllSelector, fn func(model *MongoModel) error) error {
+	iter, err := this.Iterate(ctx, selector)
+
+	if err != nil {
+		return err
+	}
+
+	for iter.Next() {
+		if err = fn(iter.Model()); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+
+	return iter.Close()
+}
+
+// Key must be unique, prefix it with "-" for descending order. A nil after returns the first page.
+func (this *MongoModel) FindAfter(result interface{}, key string, after interface{}, selector FindAllSelector) error {
+	selector, err := this.compileSelector(selector)
+
+	if err != nil {
+		return err
+	}
+
+	req_start := time.Now().UnixNano() / int64(time.Millisecond)
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
+	cond := this.scope(selector.Cond)
+
+	if cond == nil {
+		cond = bson.M{}
+	}
+
+	if after != nil {
+		field := strings.TrimPrefix(key, "-")
+		op := "$gt"
+
+		if strings.HasPrefix(key, "-") {
+			op = "$lt"
+		}
+
+		if len(cond) == 0 {
+			cond = bson.M{field: bson.M{op: after}}
+		} else {
+			cond = bson.M{"$and": []bson.M{cond, {field: bson.M{op: after}}}}
+		}
+	}
+
+	err = collection.FindAll(cond, []string{key}, selector.Col, 0, selector.Limit, result)
+
+	if err == nil {
+		err = base.RunAfterFindAll(result)
+	}
+
+	if err != nil {
+		logger.Error("[%v] FindAfter %v failed %v", this.LogID(), cond, err)
+	} else {
+		logger.Notice("[%v] FindAfter %v Success.", this.LogID(), cond)
+	}
+
+	return this.LOG_RET_ERR(this.Data.TableName(), req_start, "FindAfter", cond, err)
+}
+
+func (this *MongoIterator) Next() bool {
+	if this.closed || this.err != nil {
+		return false
+	}
+
+	if err := this.ctx.Err(); err != nil {
+		this.err = err
+		return false
+	}
+
+	data, ok := reflect.New(reflect.TypeOf(this.model.Data).Elem()).Interface().(base.IActiveRecord)
+
+	if !ok || !this.iter.Next(data) {
+		return false
+	}
+
+	if err := base.RunAfterFind(data); err != nil {
+		this.err = err
+		return false
+	}
+
+	model := &MongoModel{tx: this.model.tx}
+	model.RequestID = this.model.RequestID
+	model.Data = data
+	model.Exists = true
+	model.RefreshOldAttr()
+	this.current = model
+	this.count++
+
+	return true
+}
+
+func (this *MongoIterator) Model() *MongoModel {
+	return this.current
+}
+
+func (this *MongoIterator) Err() error {
+	if this.err != nil {
+		return this.err
+	}
+
+	return this.iter.Err()
+}
+
+func (this *MongoIterator) Close() error {
+	if this.closed {
+		return this.Err()
+	}
+
+	this.closed = true
+
+	if err := this.iter.Close(); err != nil && this.err == nil {
+		this.err = err
+	}
+
+	this.collection.Close()
+	err := this.Err()
+
+	if err != nil {
+		logger.Error("[%v] Iterate %v failed %v", this.model.LogID(), this.cond, err)
+	} else {
+		logger.Notice("[%v] Iterate %v %v records Success.", this.model.LogID(), this.cond, this.count)
+	}
+
+	return this.model.LOG_RET_ERR(this.model.Data.TableName(), this.req_start, "Iterate", this.cond, err)
+}
@@ -0,0 +1,211 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/helper/logger"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"sync"
+	"time"
+)
+
+const (
+	MIGRATION_COLLECTION = "col_migrations"
+)
+
+var (
+	gMigrations base.MigrationRegistry[*Migration]
+	gIndexers   []base.IActiveRecord
+	gIndexLock  sync.Mutex
+)
+
+type CollectionIndexer interface {
+	Indexes() []mgo.Index
+}
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(ctx context.Context) error
+	Down    func(ctx context.Context) error
+}
+
+func (this *Migration) MigrationVersion() int64 {
+	return this.Version
+}
+
+func (this *Migration) MigrationName() string {
+	return this.Name
+}
+
+type MigrationStatus = base.MigrationStatus
+
+// Pending is set while Up or Down runs, a record left pending means the migration was
+// interrupted halfway and has to be checked by hand.
+type migrationRecord struct {
+	Version   int64     `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+	Pending   bool      `bson:"pending,omitempty"`
+}
+
+func RegisterMigration(migrations ...*Migration) error {
+	return RegisterNamedMigration(base.DEFAULT_CONNECTION, migrations...)
+}
+
+// Rejects versions already registered on the connection.
+func RegisterNamedMigration(name string, migrations ...*Migration) error {
+	err := gMigrations.Register(name, migrations...)
+
+	if err != nil {
+		logger.Error("Register migration failed %v", err.Error())
+	}
+
+	return err
+}
+
+func RegisterIndexes(records ...base.IActiveRecord) {
+	gIndexLock.Lock()
+	defer gIndexLock.Unlock()
+
+	gIndexers = append(gIndexers, records...)
+}
+
+func SyncIndexes() error {
+	gIndexLock.Lock()
+	records := append([]base.IActiveRecord(nil), gIndexers...)
+	gIndexLock.Unlock()
+
+	for _, record := range records {
+		indexer, ok := record.(CollectionIndexer)
+
+		if !ok {
+			continue
+		}
+
+		model := &MongoModel{}
+		model.Data = record
+
+		for _, index := range indexer.Indexes() {
+			if err := model.EnsureIndex(index); err != nil {
+				logger.Error("EnsureIndex %v %v failed %v", record.TableName(), index.Key, err.Error())
+				return err
+			}
+		}
+
+		logger.Warning("EnsureIndex %v %v indexes", record.TableName(), len(indexer.Indexes()))
+	}
+
+	return nil
+}
+
+func appliedMigrations(name string) (map[int64]time.Time, error) {
+	collection := namedCollection(name, MIGRATION_COLLECTION, nil)
+	defer collection.Close()
+	var records []*migrationRecord
+
+	if err := collection.FindAll(bson.M{}, []string{"_id"}, nil, 0, 0, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]time.Time)
+
+	for _, record := range records {
+		if record.Pending {
+			return nil, fmt.Errorf("Migration %v-%v of %v was interrupted, check it and remove its record",
+				record.Version, record.Name, name)
+		}
+
+		applied[record.Version] = record.AppliedAt
+	}
+
+	return applied, nil
+}
+
+func MigrationStatuses(name string) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gMigrations.Statuses(name, applied), nil
+}
+
+// Up is not atomic with its record, which is upserted pending before Up runs and
+// completed after it. Steps <= 0 applies every pending migration.
+func Migrate(ctx context.Context, name string, steps int) error {
+	applied, err := appliedMigrations(name)
+
+	if err != nil {
+		return err
+	}
+
+	collection := namedCollection(name, MIGRATION_COLLECTION, nil)
+	defer collection.Close()
+
+	for _, migration := range gMigrations.Pending(name, applied, steps) {
+		if migration.Up == nil {
+			return fmt.Errorf("Migration %v has no Up", migration.Version)
+		}
+
+		if err = markMigration(collection, migration, bson.M{"name": migration.Name, "pending": true}); err == nil {
+			if err = migration.Up(ctx); err != nil {
+				collection.RemoveId(migration.Version)
+			} else {
+				err = markMigration(collection, migration, bson.M{"applied_at": time.Now(), "pending": false})
+			}
+		}
+
+		if err != nil {
+			logger.Error("Migrate %v %v-%v failed %v", name, migration.Version, migration.Name, err.Error())
+			return err
+		}
+
+		logger.Warning("Migrate %v %v-%v applied", name, migration.Version, migration.Name)
+	}
+
+	return nil
+}
+
+// The record is marked pending while Down runs. Steps <= 0 rolls back the latest applied
+// migration only.
+func Rollback(ctx context.Context, name string, steps int) error {
+	applied, err := appliedMigrations(name)
+
+	if err != nil {
+		return err
+	}
+
+	collection := namedCollection(name, MIGRATION_COLLECTION, nil)
+	defer collection.Close()
+
+	for _, migration := range gMigrations.Applied(name, applied, steps) {
+		if migration.Down == nil {
+			return fmt.Errorf("Migration %v has no Down", migration.Version)
+		}
+
+		if err = markMigration(collection, migration, bson.M{"pending": true}); err == nil {
+			if err = migration.Down(ctx); err != nil {
+				markMigration(collection, migration, bson.M{"pending": false})
+			} else {
+				err = collection.RemoveId(migration.Version)
+			}
+		}
+
+		if err != nil {
+			logger.Error("Rollback %v %v-%v failed %v", name, migration.Version, migration.Name, err.Error())
+			return err
+		}
+
+		logger.Warning("Rollback %v %v-%v done", name, migration.Version, migration.Name)
+	}
+
+	return nil
+}
+
+func markMigration(collection mongoCollection, migration *Migration, fields bson.M) error {
+	return collection.Upsert(bson.M{"_id": migration.Version}, bson.M{"$set": fields})
+}
@@ -1,24 +1,49 @@
 package mongo
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/frame/cache"
+	"github.com/derekyu332/goii/frame/query"
 	"github.com/derekyu332/goii/helper/extend"
 	"github.com/derekyu332/goii/helper/logger"
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
+	driver "go.mongodb.org/mongo-driver/mongo"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 var (
-	gDialInfo    *mgo.DialInfo
-	gDbName      string
-	gMainSession *mgo.Session
+	gConnections          = make(map[string]*mongoConnection)
+	gConnectionLock       sync.RWMutex
+	ErrSessionUnsupported = errors.New("Session not supported by the official driver")
 )
 
+type mongoConnection struct {
+	dialInfo *mgo.DialInfo
+	dbName   string
+	session  *mgo.Session
+	client   *driver.Client
+}
+
+func (this *mongoConnection) close() {
+	if this.session != nil {
+		this.session.Close()
+		this.session = nil
+	}
+
+	if this.client != nil {
+		this.client.Disconnect(context.Background())
+		this.client = nil
+	}
+}
+
 type CollectionLocker interface {
 	OptimisticLock() string
 }
@@ -33,53 +58,228 @@ type CollectionTimeTracker interface {
 
 type MongoModel struct {
 	base.Model
-	oldAttr    map[string]interface{}
-	update_seq int64
+	WithDeleted bool
+	oldAttr     map[string]interface{}
+	update_seq  int64
+	tx          *Tx
 }
 
 func init() {
 }
 
-func InitConnection(initDialInfo *mgo.DialInfo, initDbName string) {
-	gDialInfo = initDialInfo
-	gDbName = initDbName
-	logger.Warning("Try Connect...")
+func InitConnection(initDialInfo *mgo.DialInfo, initDbName string) error {
+	return InitNamedConnection(base.DEFAULT_CONNECTION, initDialInfo, initDbName)
+}
+
+func InitNamedConnection(name string, initDialInfo *mgo.DialInfo, initDbName string) error {
+	logger.Warning("Try Connect %v...", name)
+	session, err := mgo.DialWithInfo(initDialInfo)
 
-	if gMainSession != nil {
-		gMainSession.Close()
+	if err != nil {
+		return err
 	}
 
-	var err error
-	gMainSession, err = mgo.DialWithInfo(gDialInfo)
+	gConnectionLock.Lock()
+	defer gConnectionLock.Unlock()
+
+	if old, ok := gConnections[name]; ok {
+		old.close()
+	}
+
+	gConnections[name] = &mongoConnection{
+		dialInfo: initDialInfo,
+		dbName:   initDbName,
+		session:  session,
+	}
+	logger.Warning("Connect %v Success", name)
+
+	return nil
+}
+
+func GetNamedDbName(name string) string {
+	gConnectionLock.RLock()
+	defer gConnectionLock.RUnlock()
+
+	if conn, ok := gConnections[name]; ok {
+		return conn.dbName
+	}
+
+	return ""
+}
+
+// Sessions only exist for mgo connections, connections of DRIVER_OFFICIAL return
+// ErrSessionUnsupported. Callers must Close the session.
+func GetNamedSession(name string) (*mgo.Session, error) {
+	gConnectionLock.Lock()
+	defer gConnectionLock.Unlock()
+
+	conn, ok := gConnections[name]
+
+	if !ok {
+		return nil, fmt.Errorf("Connection %v not initialized", name)
+	} else if conn.client != nil {
+		return nil, ErrSessionUnsupported
+	}
+
+	if conn.session == nil {
+		session, err := mgo.DialWithInfo(conn.dialInfo)
+
+		if err != nil {
+			logger.Warning("Connect %v failed %v", name, err.Error())
+			return nil, err
+		}
+
+		session.SetMode(mgo.Strong, false)
+		conn.session = session
+	}
+
+	return conn.session.Copy(), nil
+}
+
+func getNamedClient(name string) *driver.Client {
+	gConnectionLock.RLock()
+	defer gConnectionLock.RUnlock()
+
+	if conn, ok := gConnections[name]; ok {
+		return conn.client
+	}
+
+	return nil
+}
+
+func namedCollection(name string, colName string, tx *Tx) mongoCollection {
+	return contextCollection(context.Background(), name, colName, tx)
+}
+
+// Calls on the driver collection are bound to ctx, mgo sessions only honour its deadline.
+func contextCollection(ctx context.Context, name string, colName string, tx *Tx) mongoCollection {
+	if ctx == nil {
+		ctx = context.Background()
+	} else if err := ctx.Err(); err != nil {
+		return &errCollection{err: err}
+	}
+
+	if client := getNamedClient(name); client != nil {
+		if tx != nil && tx.name == name {
+			ctx = tx.ctx
+		}
+
+		return newDriverCollection(ctx, client, GetNamedDbName(name), colName)
+	}
+
+	session, err := GetNamedSession(name)
 
 	if err != nil {
-		panic(err)
+		return &errCollection{err: fmt.Errorf("Connection %v not available %v", name, err.Error())}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		session.SetSyncTimeout(time.Until(deadline))
+		session.SetSocketTimeout(time.Until(deadline))
 	}
 
-	logger.Warning("Connect Success")
+	return newMgoCollection(session, GetNamedDbName(name), colName)
 }
 
-func (this *MongoModel) GetDbName() string {
-	return gDbName
+func connectionNames() []string {
+	gConnectionLock.RLock()
+	defer gConnectionLock.RUnlock()
+
+	names := make([]string, 0, len(gConnections))
+
+	for name := range gConnections {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
 }
 
-func (this *MongoModel) GetSession() *mgo.Session {
-	if gMainSession == nil {
-		var err error
-		gMainSession, err = mgo.DialWithInfo(gDialInfo)
+func Ping(ctx context.Context) error {
+	names := connectionNames()
+
+	if len(names) == 0 {
+		return errors.New("Connection not initialized")
+	}
+
+	for _, name := range names {
+		if client := getNamedClient(name); client != nil {
+			if err := client.Ping(ctx, nil); err != nil {
+				return fmt.Errorf("%v: %v", name, err.Error())
+			}
+
+			continue
+		}
+
+		session, err := GetNamedSession(name)
 
 		if err != nil {
-			return nil
+			return fmt.Errorf("%v: %v", name, err.Error())
+		}
+
+		if deadline, ok := ctx.Deadline(); ok {
+			session.SetSyncTimeout(time.Until(deadline))
+			session.SetSocketTimeout(time.Until(deadline))
 		}
 
-		gMainSession.SetMode(mgo.Strong, false)
+		err = session.Ping()
+		session.Close()
+
+		if err != nil {
+			return fmt.Errorf("%v: %v", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func CloseConnection() {
+	gConnectionLock.Lock()
+	defer gConnectionLock.Unlock()
+
+	for name, conn := range gConnections {
+		conn.close()
+		delete(gConnections, name)
+		logger.Warning("Connection %v Closed", name)
 	}
+}
+
+func (this *MongoModel) connectionName() string {
+	return base.ConnectionOf(this.Data)
+}
+
+func (this *MongoModel) GetDbName() string {
+	return GetNamedDbName(this.connectionName())
+}
+
+// Nil when the connection fails or uses the official driver, see Session for the reason.
+func (this *MongoModel) GetSession() *mgo.Session {
+	session, err := this.Session()
 
-	session := gMainSession.Copy()
+	if err != nil {
+		logger.Warning("[%v] GetSession failed %v", this.LogID(), err.Error())
+		return nil
+	}
 
 	return session
 }
 
+// See GetNamedSession, use Collection style methods of the model on the official driver.
+func (this *MongoModel) Session() (*mgo.Session, error) {
+	return GetNamedSession(this.connectionName())
+}
+
+func (this *MongoModel) WithContext(ctx context.Context) *MongoModel {
+	this.BindContext(ctx)
+
+	return this
+}
+
+func (this *MongoModel) collection(colName string) mongoCollection {
+	return contextCollection(this.Context(), this.connectionName(), colName, this.tx)
+}
+
 func (this *MongoModel) LOG_RET_ERR(colName string, tStart int64, op string, cond bson.M, err error) error {
 	if err == nil && this.Data != nil {
 		if profile, ok := this.Data.(CollectionProfile); ok && profile.DisableProfile(op) {
@@ -105,49 +305,48 @@ func (this *MongoModel) LOG_RET_ERR(colName string, tStart int64, op string, con
 }
 
 func (this *MongoModel) GetAutoIncrement() (int64, error) {
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(gDbName).C("col_counters")
-	change := mgo.Change{
-		Update:    bson.M{"$inc": bson.M{"auto_increment": 1}},
-		Upsert:    true,
-		ReturnNew: true,
-	}
+	collection := this.collection("col_counters")
+	defer collection.Close()
 	doc := struct{ Auto_increment int64 }{}
-	_, err := collection.Find(bson.M{"_id": this.Data.TableName()}).Apply(change, &doc)
+	_, err := collection.Apply(bson.M{"_id": this.Data.TableName()}, bson.M{"$inc": bson.M{"auto_increment": 1}},
+		true, true, &doc)
 
 	if err != nil {
-		logger.Error("[%v] get AutoIncrement failed %v", this.RequestID, err)
+		logger.Error("[%v] get AutoIncrement failed %v", this.LogID(), err)
 		return 0, err
 	}
 
-	logger.Notice("[%v] get AutoIncrement %v", this.RequestID, doc.Auto_increment)
+	logger.Notice("[%v] get AutoIncrement %v", this.LogID(), doc.Auto_increment)
 
 	return doc.Auto_increment, nil
 }
 
 func (this *MongoModel) Count(cond bson.M) (int, error) {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(this.GetDbName()).C(this.Data.TableName())
-	c, err := collection.Find(cond).Count()
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
+	c, err := collection.Count(this.scope(cond))
 
 	return c, this.LOG_RET_ERR(this.Data.TableName(), req_start, "Count", cond, err)
 }
 
 func (this *MongoModel) GroupCount(key string) ([]bson.M, error) {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(this.GetDbName()).C(this.Data.TableName())
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
 	result := make([]bson.M, 0)
-	err := collection.Pipe([]bson.M{
+	pipeline := []bson.M{
 		bson.M{"$group": bson.M{"_id": fmt.Sprintf("$%v", key), "count": bson.M{"$sum": 1}}},
-	}).All(&result)
+	}
+
+	if match := this.scope(nil); len(match) > 0 {
+		pipeline = append([]bson.M{bson.M{"$match": match}}, pipeline...)
+	}
+
+	err := collection.Pipe(pipeline, &result)
 
 	if err != nil {
-		logger.Error("[%v] GroupCount %v failed %v", this.RequestID, key, err)
+		logger.Error("[%v] GroupCount %v failed %v", this.LogID(), key, err)
 	}
 
 	return result, this.LOG_RET_ERR(this.Data.TableName(), req_start, "GroupCount", bson.M{"key": key}, err)
@@ -155,32 +354,31 @@ func (this *MongoModel) GroupCount(key string) ([]bson.M, error) {
 
 func (this *MongoModel) Max(cond bson.M, key string) (int, error) {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(this.GetDbName()).C(this.Data.TableName())
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
 	result := make([]bson.M, 0)
 	var err error
 	var sum int64
 
-	if cond != nil {
+	if match := this.scope(cond); len(match) > 0 {
 		err = collection.Pipe([]bson.M{
-			bson.M{"$match": cond},
+			bson.M{"$match": match},
 			bson.M{"$project": bson.M{key: 1}},
 			bson.M{"$group": bson.M{"_id": nil, "max": bson.M{"$max": fmt.Sprintf("$%v", key)}}},
-		}).All(&result)
+		}, &result)
 	} else {
 		err = collection.Pipe([]bson.M{
 			bson.M{"$project": bson.M{key: 1}},
 			bson.M{"$group": bson.M{"_id": nil, "max": bson.M{"$max": fmt.Sprintf("$%v", key)}}},
-		}).All(&result)
+		}, &result)
 	}
 
 	if err != nil {
-		logger.Error("[%v] Sum %v %v failed %v", this.RequestID, cond, key, err)
+		logger.Error("[%v] Sum %v %v failed %v", this.LogID(), cond, key, err)
 	} else if len(result) > 0 {
 		v, _ := result[0]["max"]
 		sum, _ = extend.InterfaceToInt64(v)
-		logger.Notice("[%v] %v", this.RequestID, result)
+		logger.Notice("[%v] %v", this.LogID(), result)
 	}
 
 	return int(sum), this.LOG_RET_ERR(this.Data.TableName(), req_start, "Max", bson.M{"key": key}, err)
@@ -188,32 +386,31 @@ func (this *MongoModel) Max(cond bson.M, key string) (int, error) {
 
 func (this *MongoModel) Sum(cond bson.M, key string) (int64, error) {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(this.GetDbName()).C(this.Data.TableName())
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
 	result := make([]bson.M, 0)
 	var err error
 	var sum int64
 
-	if cond != nil {
+	if match := this.scope(cond); len(match) > 0 {
 		err = collection.Pipe([]bson.M{
-			bson.M{"$match": cond},
+			bson.M{"$match": match},
 			bson.M{"$project": bson.M{key: 1}},
 			bson.M{"$group": bson.M{"_id": nil, "sum": bson.M{"$sum": fmt.Sprintf("$%v", key)}}},
-		}).All(&result)
+		}, &result)
 	} else {
 		err = collection.Pipe([]bson.M{
 			bson.M{"$project": bson.M{key: 1}},
 			bson.M{"$group": bson.M{"_id": nil, "sum": bson.M{"$sum": fmt.Sprintf("$%v", key)}}},
-		}).All(&result)
+		}, &result)
 	}
 
 	if err != nil {
-		logger.Error("[%v] Sum %v %v failed %v", this.RequestID, cond, key, err)
+		logger.Error("[%v] Sum %v %v failed %v", this.LogID(), cond, key, err)
 	} else if len(result) > 0 {
 		v, _ := result[0]["sum"]
 		sum, _ = extend.InterfaceToInt64(v)
-		logger.Notice("[%v] %v", this.RequestID, result)
+		logger.Notice("[%v] %v", this.LogID(), result)
 	}
 
 	return sum, this.LOG_RET_ERR(this.Data.TableName(), req_start, "Sum", bson.M{"key": key}, err)
@@ -221,34 +418,33 @@ func (this *MongoModel) Sum(cond bson.M, key string) (int64, error) {
 
 func (this *MongoModel) DistinctCount(cond bson.M, key string) (int, error) {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(this.GetDbName()).C(this.Data.TableName())
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
 	result := make([]bson.M, 0)
 	var err error
 	var count int64
 
-	if cond != nil {
+	if match := this.scope(cond); len(match) > 0 {
 		err = collection.Pipe([]bson.M{
-			bson.M{"$match": cond},
+			bson.M{"$match": match},
 			bson.M{"$project": bson.M{key: 1}},
 			bson.M{"$group": bson.M{"_id": fmt.Sprintf("$%v", key)}},
 			bson.M{"$group": bson.M{"_id": nil, "count": bson.M{"$sum": 1}}},
-		}).All(&result)
+		}, &result)
 	} else {
 		err = collection.Pipe([]bson.M{
 			bson.M{"$project": bson.M{key: 1}},
 			bson.M{"$group": bson.M{"_id": fmt.Sprintf("$%v", key)}},
 			bson.M{"$group": bson.M{"_id": nil, "count": bson.M{"$sum": 1}}},
-		}).All(&result)
+		}, &result)
 	}
 
 	if err != nil {
-		logger.Error("[%v] DistinctCount %v %v failed %v", this.RequestID, cond, key, err)
+		logger.Error("[%v] DistinctCount %v %v failed %v", this.LogID(), cond, key, err)
 	} else if len(result) > 0 {
 		v, _ := result[0]["count"]
 		count, _ = extend.InterfaceToInt64(v)
-		logger.Notice("[%v] %v", this.RequestID, result)
+		logger.Notice("[%v] %v", this.LogID(), result)
 	}
 
 	return int(count), this.LOG_RET_ERR(this.Data.TableName(), req_start, "DistinctCount", bson.M{"key": key}, err)
@@ -256,28 +452,42 @@ func (this *MongoModel) DistinctCount(cond bson.M, key string) (int, error) {
 
 func (this *MongoModel) FindOne(cond bson.M) (base.IActiveRecord, error) {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(gDbName).C(this.Data.TableName())
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
 	var context interface{}
 
 	if context_getter, ok := this.Data.(base.IRecordContext); ok {
 		context = context_getter.GetContext()
 	}
 
-	err := collection.Find(cond).One(this.Data)
+	found, err := this.cached(cond, func() (bool, error) {
+		if err := collection.FindOne(this.scope(cond), this.Data); err != nil {
+			if err == mgo.ErrNotFound {
+				return false, nil
+			}
+
+			return false, err
+		}
+
+		return true, nil
+	})
+
+	if err == nil && !found {
+		err = mgo.ErrNotFound
+	}
 
 	if err == nil {
-		logger.Notice("[%v] Find %v success", this.RequestID, cond)
-		logger.Info("[%v] %v", this.RequestID, this.Data)
+		logger.Notice("[%v] Find %v success", this.LogID(), cond)
+		logger.Info("[%v] %v", this.LogID(), this.Data)
 		this.RefreshOldAttr()
 		this.Exists = true
+		err = base.RunAfterFind(this.Data)
 	} else if err == mgo.ErrNotFound {
-		logger.Notice("[%v] Find %v no record", this.RequestID, cond)
+		logger.Notice("[%v] Find %v no record", this.LogID(), cond)
 		this.Exists = false
 		err = nil
 	} else {
-		logger.Error("[%v] Find %v failed %v", this.RequestID, cond, err)
+		logger.Error("[%v] Find %v failed %v", this.LogID(), cond, err)
 	}
 
 	if context != nil {
@@ -295,18 +505,47 @@ type FindAllSelector struct {
 	Page  int
 	Limit int
 	Sort  string
+	Query *query.Query
+}
+
+func (this *MongoModel) compileSelector(selector FindAllSelector) (FindAllSelector, error) {
+	if selector.Query == nil {
+		return selector, nil
+	}
+
+	if err := selector.Query.Validate(this.Data, "bson"); err != nil {
+		logger.Warning("[%v] Query %v invalid %v", this.LogID(), selector.Query.Bson(), err.Error())
+		return selector, err
+	}
+
+	selector.Cond = selector.Query.Bson()
+
+	if sorts := selector.Query.Sorts(); len(sorts) > 0 {
+		selector.Sort = strings.Join(sorts, ",")
+	}
+
+	if projection := selector.Query.Projection(); projection != nil {
+		selector.Col = projection
+	}
+
+	return selector, nil
 }
 
 func (this *MongoModel) FindAll(result interface{}, selector FindAllSelector) error {
+	selector, err := this.compileSelector(selector)
+
+	if err != nil {
+		return err
+	}
+
 	if selector.Cond == nil {
 		return errors.New("selector.Cond == nil")
 	}
 
+	selector.Cond = this.scope(selector.Cond)
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(gDbName).C(this.Data.TableName())
-	var iter *mgo.Iter
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
 	var sort string
 
 	if selector.Sort == "" {
@@ -318,27 +557,20 @@ func (this *MongoModel) FindAll(result interface{}, selector FindAllSelector) er
 	sort_slics := strings.Split(sort, ",")
 
 	if selector.Page < 0 || selector.Limit <= 0 {
-		if selector.Col != nil {
-			iter = collection.Find(selector.Cond).Sort(sort_slics...).Select(selector.Col).Iter()
-		} else {
-			iter = collection.Find(selector.Cond).Sort(sort_slics...).Iter()
-		}
+		err = collection.FindAll(selector.Cond, sort_slics, selector.Col, 0, 0, result)
 	} else {
 		offset := selector.Page * selector.Limit
-
-		if selector.Col != nil {
-			iter = collection.Find(selector.Cond).Sort(sort_slics...).Select(selector.Col).Skip(offset).Limit(selector.Limit).Iter()
-		} else {
-			iter = collection.Find(selector.Cond).Sort(sort_slics...).Skip(offset).Limit(selector.Limit).Iter()
-		}
+		err = collection.FindAll(selector.Cond, sort_slics, selector.Col, offset, selector.Limit, result)
 	}
 
-	var err error
+	if err == nil {
+		err = base.RunAfterFindAll(result)
+	}
 
-	if err = iter.All(result); err != nil {
-		logger.Error("[%v] FindAll %v failed %v", this.RequestID, selector.Cond, err)
+	if err != nil {
+		logger.Error("[%v] FindAll %v failed %v", this.LogID(), selector.Cond, err)
 	} else {
-		logger.Notice("[%v] FindAll %v Success.", this.RequestID, selector.Cond)
+		logger.Notice("[%v] FindAll %v Success.", this.LogID(), selector.Cond)
 	}
 
 	return this.LOG_RET_ERR(this.Data.TableName(), req_start, "FindAll", selector.Cond, err)
@@ -346,15 +578,15 @@ func (this *MongoModel) FindAll(result interface{}, selector FindAllSelector) er
 
 func (this *MongoModel) ForceIncrement(cond bson.M, incAttr string) error {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(gDbName).C(this.Data.TableName())
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
+	defer this.invalidateCond(cond)
 	err := collection.Update(cond, bson.M{"$inc": bson.M{incAttr: 1}})
 
 	if err != nil {
-		logger.Warning("[%v] Inc %v failed %v", this.RequestID, incAttr, err.Error())
+		logger.Warning("[%v] Inc %v failed %v", this.LogID(), incAttr, err.Error())
 	} else {
-		logger.Notice("[%v] Inc %v success", this.RequestID, incAttr)
+		logger.Notice("[%v] Inc %v success", this.LogID(), incAttr)
 	}
 
 	return this.LOG_RET_ERR(this.Data.TableName(), req_start, "ForceIncrement", cond, err)
@@ -362,15 +594,15 @@ func (this *MongoModel) ForceIncrement(cond bson.M, incAttr string) error {
 
 func (this *MongoModel) ForceMinus(cond bson.M, minusAttr string) error {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(gDbName).C(this.Data.TableName())
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
+	defer this.invalidateCond(cond)
 	err := collection.Update(cond, bson.M{"$inc": bson.M{minusAttr: -1}})
 
 	if err != nil {
-		logger.Warning("[%v] Minus %v failed %v", this.RequestID, minusAttr, err.Error())
+		logger.Warning("[%v] Minus %v failed %v", this.LogID(), minusAttr, err.Error())
 	} else {
-		logger.Notice("[%v] Minus %v success", this.RequestID, minusAttr)
+		logger.Notice("[%v] Minus %v success", this.LogID(), minusAttr)
 	}
 
 	return this.LOG_RET_ERR(this.Data.TableName(), req_start, "ForceMinus", cond, err)
@@ -410,9 +642,35 @@ func (this *MongoModel) GetDirtyAttr() map[string]interface{} {
 }
 
 func (this *MongoModel) Save() error {
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(gDbName).C(this.Data.TableName())
+	insert := !this.Exists
+
+	if err := base.RunBeforeValidate(this.Data); err != nil {
+		logger.Warning("[%v] BeforeValidate %v failed %v", this.LogID(), this.Data.GetId(), err.Error())
+		return err
+	}
+
+	if err := this.Validate(); err != nil {
+		logger.Warning("[%v] Validate %v failed %v", this.LogID(), this.Data.GetId(), err.Error())
+		return err
+	}
+
+	if err := base.RunBeforeSave(this.Data, insert); err != nil {
+		logger.Warning("[%v] BeforeSave %v failed %v", this.LogID(), this.Data.GetId(), err.Error())
+		return err
+	}
+
+	if err := this.save(); err != nil {
+		return err
+	}
+
+	this.refreshCache(false)
+
+	return base.RunAfterSave(this.Data, insert)
+}
+
+func (this *MongoModel) save() error {
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
 	var err error
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
 
@@ -424,7 +682,7 @@ func (this *MongoModel) Save() error {
 		dirty_attr := this.GetDirtyAttr()
 
 		if len(dirty_attr) <= 0 {
-			logger.Notice("[%v] Update %v No Change", this.RequestID, this.Data.GetId())
+			logger.Notice("[%v] Update %v No Change", this.LogID(), this.Data.GetId())
 
 			return nil
 		}
@@ -439,9 +697,10 @@ func (this *MongoModel) Save() error {
 		}
 
 		if err != nil {
-			logger.Warning("[%v] Update %v failed %v", this.RequestID, dirty_attr, err.Error())
+			logger.Warning("[%v] Update %v failed %v", this.LogID(), dirty_attr, err.Error())
 		} else {
-			logger.Notice("[%v] Update %v success", this.RequestID, dirty_attr)
+			logger.Notice("[%v] Update %v success", this.LogID(), dirty_attr)
+			this.writeAudit(this.auditLog(AUDIT_UPDATE, auditOld(this.oldAttr, dirty_attr), auditCopy(dirty_attr)))
 			new_update_seq := this.update_seq + 1
 			this.RefreshOldAttr()
 			this.update_seq = new_update_seq
@@ -452,11 +711,12 @@ func (this *MongoModel) Save() error {
 		err = collection.Insert(this.Data)
 
 		if err != nil {
-			logger.Warning("[%v] Insert %v failed %v", this.RequestID, this.Data, err.Error())
+			logger.Warning("[%v] Insert %v failed %v", this.LogID(), this.Data, err.Error())
 		} else {
-			logger.Notice("[%v] Insert %v success", this.RequestID, this.Data)
+			logger.Notice("[%v] Insert %v success", this.LogID(), this.Data)
 			this.Exists = true
 			this.RefreshOldAttr()
+			this.writeAudit(this.auditLog(AUDIT_INSERT, nil, auditCopy(this.oldAttr)))
 		}
 
 		return this.LOG_RET_ERR(this.Data.TableName(), req_start, "Insert", bson.M{"_id": this.Data.GetId()}, err)
@@ -468,32 +728,56 @@ func (this *MongoModel) Remove() error {
 		return nil
 	}
 
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(gDbName).C(this.Data.TableName())
+	if err := base.RunBeforeDelete(this.Data); err != nil {
+		logger.Warning("[%v] BeforeDelete %v failed %v", this.LogID(), this.Data.GetId(), err.Error())
+		return err
+	}
+
+	if err := this.remove(); err != nil {
+		return err
+	}
+
+	this.refreshCache(true)
+
+	return base.RunAfterDelete(this.Data)
+}
+
+func (this *MongoModel) remove() error {
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
 	var err error
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	err = collection.RemoveId(this.Data.GetId())
+	op := "Remove"
+
+	if deleter, ok := this.Data.(CollectionSoftDeleter); ok {
+		// Records deleted before are not found, rather than deleted and audited again.
+		op = "SoftRemove"
+		err = collection.Update(bson.M{
+			"_id":                    this.Data.GetId(),
+			deleter.SoftDeleteAttr(): bson.M{"$in": []interface{}{nil, time.Time{}}},
+		}, bson.M{"$set": bson.M{deleter.SoftDeleteAttr(): time.Now()}})
+	} else {
+		err = collection.RemoveId(this.Data.GetId())
+	}
 
 	if err != nil {
-		logger.Warning("[%v] Remove %v failed %v", this.RequestID, this.Data.GetId(), err.Error())
+		logger.Warning("[%v] Remove %v failed %v", this.LogID(), this.Data.GetId(), err.Error())
+	} else {
+		this.writeAudit(this.auditLog(AUDIT_DELETE, auditCopy(this.oldAttr), nil))
 	}
 
-	return this.LOG_RET_ERR(this.Data.TableName(), req_start, "Remove", bson.M{"_id": this.Data.GetId()}, err)
+	return this.LOG_RET_ERR(this.Data.TableName(), req_start, op, bson.M{"_id": this.Data.GetId()}, err)
 }
 
 func (this *MongoModel) RemoveAll(cond bson.M) (int, error) {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(gDbName).C(this.Data.TableName())
-	info, err := collection.RemoveAll(cond)
-	var removed int
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
+	defer this.invalidateCond(cond)
+	removed, err := collection.RemoveAll(cond)
 
 	if err != nil {
-		logger.Warning("[%v] RemoveAll %v failed %v", this.RequestID, cond, err.Error())
-	} else {
-		removed = info.Removed
+		logger.Warning("[%v] RemoveAll %v failed %v", this.LogID(), cond, err.Error())
 	}
 
 	return removed, this.LOG_RET_ERR(this.Data.TableName(), req_start, "RemoveAll", cond, err)
@@ -501,15 +785,15 @@ func (this *MongoModel) RemoveAll(cond bson.M) (int, error) {
 
 func (this *MongoModel) UpdateAll(cond bson.M, update interface{}) error {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(gDbName).C(this.Data.TableName())
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
+	defer this.invalidateCond(cond)
 	_, err := collection.UpdateAll(cond, update)
 
 	if err != nil {
-		logger.Warning("[%v] UpdateAll %v failed %v", this.RequestID, cond, err.Error())
+		logger.Warning("[%v] UpdateAll %v failed %v", this.LogID(), cond, err.Error())
 	} else {
-		logger.Notice("[%v] UpdateAll %v success", this.RequestID, cond)
+		logger.Notice("[%v] UpdateAll %v success", this.LogID(), cond)
 	}
 
 	return this.LOG_RET_ERR(this.Data.TableName(), req_start, "UpdateAll", cond, err)
@@ -517,9 +801,8 @@ func (this *MongoModel) UpdateAll(cond bson.M, update interface{}) error {
 
 func (this *MongoModel) Update(cond bson.M, update interface{}, apply bool) error {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(gDbName).C(this.Data.TableName())
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
 
 	if cond == nil {
 		if !this.Exists {
@@ -529,21 +812,17 @@ func (this *MongoModel) Update(cond bson.M, update interface{}, apply bool) erro
 		cond = bson.M{"_id": this.Data.GetId()}
 	}
 
-	if apply == true {
-		change := mgo.Change{
-			Update:    update,
-			Upsert:    true,
-			ReturnNew: true,
-		}
+	defer this.invalidateCond(cond)
 
-		info, err := collection.Find(cond).Apply(change, this.Data)
+	if apply == true {
+		updated, err := collection.Apply(cond, update, true, true, this.Data)
 
 		if err != nil {
-			logger.Warning("[%v] Update %v failed %v", this.RequestID, cond, err.Error())
-		} else if info.Updated <= 0 {
-			logger.Warning("[%v] Update %v No change", this.RequestID, cond)
+			logger.Warning("[%v] Update %v failed %v", this.LogID(), cond, err.Error())
+		} else if updated <= 0 {
+			logger.Warning("[%v] Update %v No change", this.LogID(), cond)
 		} else {
-			logger.Notice("[%v] Update %v success", this.RequestID, cond)
+			logger.Notice("[%v] Update %v success", this.LogID(), cond)
 		}
 
 		return this.LOG_RET_ERR(this.Data.TableName(), req_start, "Apply", cond, err)
@@ -551,9 +830,9 @@ func (this *MongoModel) Update(cond bson.M, update interface{}, apply bool) erro
 		err := collection.Update(cond, update)
 
 		if err != nil {
-			logger.Warning("[%v] Update %v failed %v", this.RequestID, cond, err.Error())
+			logger.Warning("[%v] Update %v failed %v", this.LogID(), cond, err.Error())
 		} else {
-			logger.Notice("[%v] Update %v success", this.RequestID, cond)
+			logger.Notice("[%v] Update %v success", this.LogID(), cond)
 		}
 
 		return this.LOG_RET_ERR(this.Data.TableName(), req_start, "Update", cond, err)
@@ -562,23 +841,78 @@ func (this *MongoModel) Update(cond bson.M, update interface{}, apply bool) erro
 
 func (this *MongoModel) Upsert(cond bson.M, update interface{}) error {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(gDbName).C(this.Data.TableName())
-	_, err := collection.Upsert(cond, update)
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
+	defer this.invalidateCond(cond)
+	err := collection.Upsert(cond, update)
 
 	if err != nil {
-		logger.Warning("[%v] Upsert %v failed %v", this.RequestID, cond, err.Error())
+		logger.Warning("[%v] Upsert %v failed %v", this.LogID(), cond, err.Error())
 	} else {
-		logger.Notice("[%v] Upsert %v success", this.RequestID, cond)
+		logger.Notice("[%v] Upsert %v success", this.LogID(), cond)
 	}
 
 	return this.LOG_RET_ERR(this.Data.TableName(), req_start, "Upsert", cond, err)
 }
 
+// Reads inside a Tx or including soft deleted documents bypass the shared cache.
+func (this *MongoModel) cached(cond bson.M, load func() (bool, error)) (bool, error) {
+	if !cache.Cacheable(this.Data) || this.tx != nil || this.WithDeleted {
+		return load()
+	}
+
+	var key string
+
+	if id, ok := idOf(cond); ok {
+		key = cache.IdKey(this.Context(), this.Data, id)
+	} else {
+		key = cache.QueryKey(this.Context(), this.Data, cond)
+	}
+
+	return cache.Load(this.Context(), key, this.Data, load)
+}
+
+// Writes inside a Tx are not committed yet, so they only invalidate.
+func (this *MongoModel) refreshCache(deleted bool) {
+	if !cache.Cacheable(this.Data) {
+		return
+	}
+
+	if deleted || this.tx != nil {
+		cache.Invalidate(this.Context(), this.Data, this.Data.GetId())
+	} else {
+		cache.Store(this.Context(), this.Data)
+	}
+}
+
+func (this *MongoModel) invalidateCond(cond bson.M) {
+	if !cache.Cacheable(this.Data) {
+		return
+	}
+
+	if id, ok := idOf(cond); ok {
+		cache.Invalidate(this.Context(), this.Data, id)
+	} else {
+		cache.InvalidateTable(this.Context(), this.Data)
+	}
+}
+
+func idOf(cond bson.M) (interface{}, bool) {
+	if len(cond) != 1 {
+		return nil, false
+	}
+
+	id, ok := cond["_id"]
+
+	if _, is_op := id.(bson.M); is_op {
+		return nil, false
+	}
+
+	return id, ok
+}
+
 func (this *MongoModel) EnsureIndex(index mgo.Index) error {
-	session := this.GetSession()
-	defer session.Close()
-	collection := session.DB(gDbName).C(this.Data.TableName())
+	collection := this.collection(this.Data.TableName())
+	defer collection.Close()
 	return collection.EnsureIndex(index)
 }
@@ -0,0 +1,102 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/helper/logger"
+	"github.com/globalsign/mgo/bson"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+type txKey string
+
+type Tx struct {
+	RequestID int64
+	name      string
+	ctx       context.Context
+	bound     []*MongoModel
+}
+
+func TxFromContext(ctx context.Context, name string) *Tx {
+	if ctx == nil {
+		return nil
+	}
+
+	tx, _ := ctx.Value(txKey(name)).(*Tx)
+
+	return tx
+}
+
+func WithTransaction(ctx context.Context, fn func(tx *Tx) error) error {
+	return WithNamedTransaction(ctx, base.DEFAULT_CONNECTION, fn)
+}
+
+func WithNamedTransaction(ctx context.Context, name string, fn func(tx *Tx) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if parent := TxFromContext(ctx, name); parent != nil {
+		return fn(parent)
+	}
+
+	client := getNamedClient(name)
+
+	if client == nil {
+		return errors.New("Transaction requires the official driver")
+	}
+
+	session, err := client.StartSession()
+
+	if err != nil {
+		return err
+	}
+
+	defer session.EndSession(context.Background())
+	tx := &Tx{name: name}
+	tx.RequestID, _ = ctx.Value(base.KEY_REQUEST_ID).(int64)
+	defer tx.unbind()
+	req_start := time.Now().UnixNano() / int64(time.Millisecond)
+
+	_, err = session.WithTransaction(ctx, func(sctx driver.SessionContext) (interface{}, error) {
+		tx.ctx = context.WithValue(sctx, txKey(name), tx)
+		return nil, fn(tx)
+	})
+
+	if err != nil {
+		logger.Warning("[%v] Transaction %v failed %v", tx.RequestID, name, err.Error())
+	}
+
+	model := &MongoModel{}
+	model.RequestID = tx.RequestID
+
+	return model.LOG_RET_ERR(name, req_start, "Transaction", bson.M{}, err)
+}
+
+func (this *Tx) Context() context.Context {
+	return this.ctx
+}
+
+func (this *Tx) Bind(models ...*MongoModel) {
+	for _, model := range models {
+		model.tx = this
+
+		if model.RequestID == 0 {
+			model.RequestID = this.RequestID
+		}
+
+		this.bound = append(this.bound, model)
+	}
+}
+
+func (this *Tx) unbind() {
+	for _, model := range this.bound {
+		if model.tx == this {
+			model.tx = nil
+		}
+	}
+
+	this.bound = nil
+}
@@ -0,0 +1,224 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/derekyu332/goii/helper/logger"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"sync"
+	"time"
+)
+
+var MONGO_RECONNECT_DELAY = []time.Duration{100 * time.Millisecond, 500 * time.Millisecond,
+	1000 * time.Millisecond, 2000 * time.Millisecond, 5000 * time.Millisecond}
+
+const (
+	MONGO_WATCH_AWAIT = 1 * time.Second
+)
+
+var (
+	gWatchers    []*MongoWatcher
+	gWatcherLock sync.Mutex
+)
+
+// Handlers return ErrSkipEvent to move past an event they gave up on, any other error
+// reopens the stream from the last handled event after a back off.
+var ErrSkipEvent = errors.New("Skip event")
+
+type WatchHandler func(event *ChangeEvent) error
+
+type UpdateDescription struct {
+	UpdatedFields bson.M   `bson:"updatedFields"`
+	RemovedFields []string `bson:"removedFields"`
+}
+
+type ChangeEvent struct {
+	OperationType     string             `bson:"operationType"`
+	DocumentKey       bson.M             `bson:"documentKey"`
+	FullDocument      bson.M             `bson:"-"`
+	UpdateDescription *UpdateDescription `bson:"updateDescription"`
+	decode            func(interface{}) error
+}
+
+func (this *ChangeEvent) Id() interface{} {
+	return this.DocumentKey["_id"]
+}
+
+func (this *ChangeEvent) Decode(record interface{}) error {
+	if this.decode == nil {
+		return mgo.ErrNotFound
+	}
+
+	return this.decode(record)
+}
+
+type changeStream interface {
+	Next(event *ChangeEvent) bool
+	ResumeToken() bson.M
+	Err() error
+	Close() error
+}
+
+type MongoWatcher struct {
+	Name       string
+	connection string
+	colName    string
+	pipeline   interface{}
+	handler    WatchHandler
+	done       chan bool
+	closeOnce  sync.Once
+	wg         sync.WaitGroup
+}
+
+func (this *MongoModel) Watch(pipeline []bson.M, handler WatchHandler) (*MongoWatcher, error) {
+	return this.WatchAs(this.Data.TableName(), pipeline, handler)
+}
+
+func (this *MongoModel) WatchAs(name string, pipeline []bson.M, handler WatchHandler) (*MongoWatcher, error) {
+	if handler == nil {
+		return nil, fmt.Errorf("Watch %v without handler", name)
+	}
+
+	if pipeline == nil {
+		pipeline = []bson.M{}
+	}
+
+	watcher := &MongoWatcher{
+		Name:       name,
+		connection: this.connectionName(),
+		colName:    this.Data.TableName(),
+		pipeline:   pipeline,
+		handler:    handler,
+		done:       make(chan bool),
+	}
+
+	gWatcherLock.Lock()
+	gWatchers = append(gWatchers, watcher)
+	gWatcherLock.Unlock()
+
+	watcher.wg.Add(1)
+	go watcher.run()
+	logger.Warning("Watch %v on %v started", name, watcher.colName)
+
+	return watcher, nil
+}
+
+func (this *MongoWatcher) Close() {
+	this.closeOnce.Do(func() {
+		close(this.done)
+	})
+
+	this.wg.Wait()
+	logger.Warning("Watch %v closed", this.Name)
+}
+
+func CloseWatchers() {
+	gWatcherLock.Lock()
+	watchers := gWatchers
+	gWatchers = nil
+	gWatcherLock.Unlock()
+
+	for _, watcher := range watchers {
+		watcher.Close()
+	}
+}
+
+func (this *MongoWatcher) run() {
+	defer this.wg.Done()
+	retry_times := 0
+
+	for {
+		err := this.consume(&retry_times)
+
+		if err == nil {
+			return
+		}
+
+		logger.Warning("Watch %v failed %v, Retry %v", this.Name, err.Error(), retry_times)
+
+		select {
+		case <-this.done:
+			return
+		case <-time.After(MONGO_RECONNECT_DELAY[retry_times]):
+		}
+
+		if retry_times < len(MONGO_RECONNECT_DELAY)-1 {
+			retry_times++
+		}
+	}
+}
+
+func (this *MongoWatcher) consume(retry_times *int) error {
+	collection := namedCollection(this.connection, this.colName, nil)
+	defer collection.Close()
+	stream, err := collection.Watch(this.pipeline, this.loadToken(), MONGO_WATCH_AWAIT)
+
+	if err != nil {
+		return err
+	}
+
+	defer stream.Close()
+
+	for {
+		select {
+		case <-this.done:
+			return nil
+		default:
+		}
+
+		event := &ChangeEvent{}
+
+		if !stream.Next(event) {
+			if err := stream.Err(); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := this.handler(event); err == ErrSkipEvent {
+			logger.Warning("Watch %v %v %v skipped", this.Name, event.OperationType, event.Id())
+		} else if err != nil {
+			return fmt.Errorf("Handle %v %v: %v", event.OperationType, event.Id(), err)
+		}
+
+		*retry_times = 0
+		this.saveToken(stream.ResumeToken())
+	}
+}
+
+func (this *MongoWatcher) tokenId() string {
+	return "watch:" + this.Name
+}
+
+func (this *MongoWatcher) loadToken() bson.M {
+	collection := namedCollection(this.connection, "col_counters", nil)
+	defer collection.Close()
+	doc := struct {
+		Resume_token bson.M
+	}{}
+
+	if err := collection.FindOne(bson.M{"_id": this.tokenId()}, &doc); err != nil {
+		if err != mgo.ErrNotFound {
+			logger.Warning("Watch %v load token failed %v", this.Name, err.Error())
+		}
+
+		return nil
+	}
+
+	return doc.Resume_token
+}
+
+func (this *MongoWatcher) saveToken(token bson.M) {
+	if token == nil {
+		return
+	}
+
+	collection := namedCollection(this.connection, "col_counters", nil)
+	defer collection.Close()
+
+	if err := collection.Upsert(bson.M{"_id": this.tokenId()}, bson.M{"$set": bson.M{"resume_token": token}}); err != nil {
+		logger.Warning("Watch %v save token failed %v", this.Name, err.Error())
+	}
+}
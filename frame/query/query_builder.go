@@ -0,0 +1,149 @@
+package query
+
+import (
+	"fmt"
+	"github.com/globalsign/mgo/bson"
+	"reflect"
+	"strings"
+	"xorm.io/builder"
+)
+
+type Query struct {
+	cond   *Cond
+	sorts  []string
+	fields []string
+}
+
+func Where(conds ...*Cond) *Query {
+	return &Query{cond: And(conds...)}
+}
+
+func (this *Query) And(conds ...*Cond) *Query {
+	this.cond = And(append([]*Cond{this.cond}, conds...)...)
+
+	return this
+}
+
+func (this *Query) Asc(fields ...string) *Query {
+	this.sorts = append(this.sorts, fields...)
+
+	return this
+}
+
+func (this *Query) Desc(fields ...string) *Query {
+	for _, field := range fields {
+		this.sorts = append(this.sorts, "-"+field)
+	}
+
+	return this
+}
+
+func (this *Query) Select(fields ...string) *Query {
+	this.fields = append(this.fields, fields...)
+
+	return this
+}
+
+func (this *Query) Cond() *Cond {
+	return this.cond
+}
+
+func (this *Query) Sorts() []string {
+	return this.sorts
+}
+
+func (this *Query) Fields() []string {
+	return this.fields
+}
+
+// Every referenced field must map to a tagged field of record. Dotted paths into embedded
+// documents are only allowed for bson and are checked by their root.
+func (this *Query) Validate(record interface{}, tagName string) error {
+	fields := append(this.cond.Fields(), this.fields...)
+
+	for _, sort := range this.sorts {
+		fields = append(fields, strings.TrimPrefix(sort, "-"))
+	}
+
+	return ValidateFields(record, tagName, fields...)
+}
+
+func ValidateFields(record interface{}, tagName string, fields ...string) error {
+	known := tagFields(record, tagName)
+
+	for _, field := range fields {
+		name := field
+
+		if tagName == "bson" {
+			name = strings.Split(field, ".")[0]
+		}
+
+		if _, ok := known[name]; !ok {
+			return fmt.Errorf("Unknown field %v", field)
+		}
+	}
+
+	return nil
+}
+
+// Quotes a column name for sql, xorm replaces the backquotes with those of the dialect.
+func Quote(column string) string {
+	return "`" + strings.Replace(column, "`", "", -1) + "`"
+}
+
+func (this *Query) Bson() bson.M {
+	return this.cond.Bson()
+}
+
+func (this *Query) Projection() bson.M {
+	if len(this.fields) == 0 {
+		return nil
+	}
+
+	projection := bson.M{}
+
+	for _, field := range this.fields {
+		projection[field] = 1
+	}
+
+	return projection
+}
+
+func (this *Query) Builder() builder.Cond {
+	return this.cond.Builder()
+}
+
+func (this *Query) String() string {
+	sql, _, err := builder.ToSQL(this.cond.Builder())
+
+	if err != nil {
+		return fmt.Sprintf("%v", this.cond.Bson())
+	}
+
+	return sql
+}
+
+func tagFields(record interface{}, tagName string) map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(record)
+
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == nil || t.Kind() != reflect.Struct {
+		return fields
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get(tagName), ",")[0]; name != "" {
+			if name != "-" {
+				fields[name] = true
+			}
+		} else {
+			fields[strings.ToLower(t.Field(i).Name)] = true
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,191 @@
+package query
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"reflect"
+	"xorm.io/builder"
+)
+
+const (
+	OP_EQ  = "eq"
+	OP_NE  = "ne"
+	OP_IN  = "in"
+	OP_NIN = "nin"
+	OP_GT  = "gt"
+	OP_GTE = "gte"
+	OP_LT  = "lt"
+	OP_LTE = "lte"
+	OP_AND = "and"
+	OP_OR  = "or"
+)
+
+type Cond struct {
+	op     string
+	field  string
+	value  interface{}
+	values []interface{}
+	conds  []*Cond
+}
+
+func Eq(field string, value interface{}) *Cond {
+	return &Cond{op: OP_EQ, field: field, value: value}
+}
+
+func Ne(field string, value interface{}) *Cond {
+	return &Cond{op: OP_NE, field: field, value: value}
+}
+
+func In(field string, values ...interface{}) *Cond {
+	return &Cond{op: OP_IN, field: field, values: flatten(values)}
+}
+
+func NotIn(field string, values ...interface{}) *Cond {
+	return &Cond{op: OP_NIN, field: field, values: flatten(values)}
+}
+
+func Gt(field string, value interface{}) *Cond {
+	return &Cond{op: OP_GT, field: field, value: value}
+}
+
+func Gte(field string, value interface{}) *Cond {
+	return &Cond{op: OP_GTE, field: field, value: value}
+}
+
+func Lt(field string, value interface{}) *Cond {
+	return &Cond{op: OP_LT, field: field, value: value}
+}
+
+func Lte(field string, value interface{}) *Cond {
+	return &Cond{op: OP_LTE, field: field, value: value}
+}
+
+// Both bounds are inclusive, a nil bound leaves that side open.
+func Range(field string, min interface{}, max interface{}) *Cond {
+	var conds []*Cond
+
+	if min != nil {
+		conds = append(conds, Gte(field, min))
+	}
+
+	if max != nil {
+		conds = append(conds, Lte(field, max))
+	}
+
+	return And(conds...)
+}
+
+func And(conds ...*Cond) *Cond {
+	return &Cond{op: OP_AND, conds: compact(conds)}
+}
+
+func Or(conds ...*Cond) *Cond {
+	return &Cond{op: OP_OR, conds: compact(conds)}
+}
+
+func (this *Cond) Fields() []string {
+	if this.op != OP_AND && this.op != OP_OR {
+		return []string{this.field}
+	}
+
+	var fields []string
+
+	for _, cond := range this.conds {
+		fields = append(fields, cond.Fields()...)
+	}
+
+	return fields
+}
+
+func (this *Cond) Bson() bson.M {
+	switch this.op {
+	case OP_EQ:
+		return bson.M{this.field: this.value}
+	case OP_NE, OP_GT, OP_GTE, OP_LT, OP_LTE:
+		return bson.M{this.field: bson.M{"$" + this.op: this.value}}
+	case OP_IN, OP_NIN:
+		return bson.M{this.field: bson.M{"$" + this.op: this.values}}
+	case OP_AND, OP_OR:
+		if len(this.conds) == 0 {
+			return bson.M{}
+		} else if len(this.conds) == 1 {
+			return this.conds[0].Bson()
+		}
+
+		docs := make([]bson.M, len(this.conds))
+
+		for i, cond := range this.conds {
+			docs[i] = cond.Bson()
+		}
+
+		return bson.M{"$" + this.op: docs}
+	}
+
+	return bson.M{}
+}
+
+func (this *Cond) Builder() builder.Cond {
+	switch this.op {
+	case OP_EQ:
+		return builder.Eq{Quote(this.field): this.value}
+	case OP_NE:
+		return builder.Neq{Quote(this.field): this.value}
+	case OP_IN:
+		return builder.In(Quote(this.field), this.values...)
+	case OP_NIN:
+		return builder.NotIn(Quote(this.field), this.values...)
+	case OP_GT:
+		return builder.Gt{Quote(this.field): this.value}
+	case OP_GTE:
+		return builder.Gte{Quote(this.field): this.value}
+	case OP_LT:
+		return builder.Lt{Quote(this.field): this.value}
+	case OP_LTE:
+		return builder.Lte{Quote(this.field): this.value}
+	case OP_AND, OP_OR:
+		conds := make([]builder.Cond, len(this.conds))
+
+		for i, cond := range this.conds {
+			conds[i] = cond.Builder()
+		}
+
+		if this.op == OP_AND {
+			return builder.And(conds...)
+		}
+
+		return builder.Or(conds...)
+	}
+
+	return builder.NewCond()
+}
+
+func flatten(values []interface{}) []interface{} {
+	if len(values) != 1 {
+		return values
+	}
+
+	v := reflect.ValueOf(values[0])
+
+	if (v.Kind() != reflect.Slice && v.Kind() != reflect.Array) || v.Type().Elem().Kind() == reflect.Uint8 {
+		return values
+	}
+
+	flat := make([]interface{}, v.Len())
+
+	for i := 0; i < v.Len(); i++ {
+		flat[i] = v.Index(i).Interface()
+	}
+
+	return flat
+}
+
+func compact(conds []*Cond) []*Cond {
+	var result []*Cond
+
+	for _, cond := range conds {
+		if cond != nil {
+			result = append(result, cond)
+		}
+	}
+
+	return result
+}
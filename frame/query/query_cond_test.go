@@ -0,0 +1,103 @@
+package query
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"reflect"
+	"testing"
+	"xorm.io/builder"
+)
+
+type testRecord struct {
+	Id      int64  `bson:"_id" sql:"id"`
+	Name    string `bson:"name" sql:"name"`
+	Profile bson.M `bson:"profile" sql:"-"`
+	Age     int
+}
+
+func TestCondBson(t *testing.T) {
+	tests := []struct {
+		name string
+		cond *Cond
+		want bson.M
+	}{
+		{"eq", Eq("name", "a"), bson.M{"name": "a"}},
+		{"ne", Ne("name", "a"), bson.M{"name": bson.M{"$ne": "a"}}},
+		{"in", In("_id", 1, 2), bson.M{"_id": bson.M{"$in": []interface{}{1, 2}}}},
+		{"in slice", In("_id", []int64{1, 2}), bson.M{"_id": bson.M{"$in": []interface{}{int64(1), int64(2)}}}},
+		{"in bytes", In("_id", []byte("ab")), bson.M{"_id": bson.M{"$in": []interface{}{[]byte("ab")}}}},
+		{"nin", NotIn("_id", 1), bson.M{"_id": bson.M{"$nin": []interface{}{1}}}},
+		{"gt", Gt("age", 1), bson.M{"age": bson.M{"$gt": 1}}},
+		{"lte", Lte("age", 1), bson.M{"age": bson.M{"$lte": 1}}},
+		{"range", Range("age", 1, 9), bson.M{"$and": []bson.M{
+			{"age": bson.M{"$gte": 1}}, {"age": bson.M{"$lte": 9}}}}},
+		{"range open", Range("age", nil, 9), bson.M{"age": bson.M{"$lte": 9}}},
+		{"and empty", And(), bson.M{}},
+		{"and nil", And(nil, Eq("name", "a"), nil), bson.M{"name": "a"}},
+		{"or", Or(Eq("name", "a"), Eq("age", 1)), bson.M{"$or": []bson.M{{"name": "a"}, {"age": 1}}}},
+	}
+
+	for _, test := range tests {
+		if got := test.cond.Bson(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: Bson() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCondBuilder(t *testing.T) {
+	tests := []struct {
+		name string
+		cond *Cond
+		sql  string
+		args []interface{}
+	}{
+		{"eq", Eq("name", "a"), "`name`=?", []interface{}{"a"}},
+		{"ne", Ne("name", "a"), "`name`<>?", []interface{}{"a"}},
+		{"in", In("id", []int{1, 2}), "`id` IN (?,?)", []interface{}{1, 2}},
+		{"nin", NotIn("id", 1, 2), "`id` NOT IN (?,?)", []interface{}{1, 2}},
+		{"gte", Gte("age", 1), "`age`>=?", []interface{}{1}},
+		{"lt", Lt("age", 1), "`age`<?", []interface{}{1}},
+		{"range", Range("age", 1, 9), "`age`>=? AND `age`<=?", []interface{}{1, 9}},
+		{"or", Or(Eq("id", 1), Eq("name", "a")), "`id`=? OR `name`=?", []interface{}{1, "a"}},
+		{"nested", And(Eq("id", 1), Or(Eq("name", "a"), Gt("age", 2))), "`id`=? AND (`name`=? OR `age`>?)",
+			[]interface{}{1, "a", 2}},
+		{"quoted", Eq("na`me", "a"), "`name`=?", []interface{}{"a"}},
+	}
+
+	for _, test := range tests {
+		sql, args, err := builder.ToSQL(test.cond.Builder())
+
+		if err != nil {
+			t.Errorf("%v: ToSQL failed %v", test.name, err)
+			continue
+		}
+
+		if sql != test.sql || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%v: ToSQL() = %v %v, want %v %v", test.name, sql, args, test.sql, test.args)
+		}
+	}
+}
+
+func TestQueryValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   *Query
+		tagName string
+		valid   bool
+	}{
+		{"bson fields", Where(Eq("_id", 1), In("name", "a")).Desc("age"), "bson", true},
+		{"bson dotted", Where(Eq("profile.level", 1)), "bson", true},
+		{"bson unknown root", Where(Eq("level.profile", 1)), "bson", false},
+		{"sql fields", Where(Eq("id", 1)).Asc("name").Select("age"), "sql", true},
+		{"sql dotted", Where(Eq("name.x", 1)), "sql", false},
+		{"sql skipped tag", Where(Eq("profile", 1)), "sql", false},
+		{"sql injection", Where(Eq("id=1 OR 1", 1)), "sql", false},
+		{"unknown sort", Where(Eq("id", 1)).Desc("rank"), "sql", false},
+		{"unknown select", Where(Eq("id", 1)).Select("rank"), "sql", false},
+	}
+
+	for _, test := range tests {
+		if err := test.query.Validate(&testRecord{}, test.tagName); (err == nil) != test.valid {
+			t.Errorf("%v: Validate() = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
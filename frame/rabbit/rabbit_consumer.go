@@ -18,40 +18,75 @@ type RabbitConsumer struct {
 	session       *RabbitSession
 	channel       *amqp.Channel
 	done          chan bool
+	stopped       chan bool
 	callbacks     map[string]chan<- message
 	gCallbackLock sync.RWMutex
 	handler       RabbitHandler
+	handlerGroup  sync.WaitGroup
+	closeOnce     sync.Once
 }
 
-func NewConsumer(amqpURI string, queueName string, key string, handler RabbitHandler) *RabbitConsumer {
+func NewConsumer(amqpURI string, queueName string, key string, handler RabbitHandler) (*RabbitConsumer, error) {
 	var err error
 	newConsumer := &RabbitConsumer{
 		uri:        amqpURI,
 		queueName:  queueName,
 		routineKey: key,
 		done:       make(chan bool),
+		stopped:    make(chan bool),
 		callbacks:  make(map[string]chan<- message),
 		handler:    handler,
 	}
-	newConsumer.session = NewSession(amqpURI)
+	newConsumer.session, err = NewSession(amqpURI)
 
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	err = newConsumer.initConsumer()
 
 	if err != nil {
-		panic(err)
+		newConsumer.session.Close()
+		return nil, err
 	}
 
-	return newConsumer
+	go newConsumer.run()
+
+	return newConsumer, nil
 }
 
 func (this *RabbitConsumer) Connection() *amqp.Connection {
 	return this.session.Connection()
 }
 
+func (this *RabbitConsumer) consumerTag() string {
+	return "consumer" + this.queueName
+}
+
+func (this *RabbitConsumer) Close() {
+	this.closeOnce.Do(func() {
+		close(this.done)
+
+		if this.channel != nil {
+			if err := this.channel.Cancel(this.consumerTag(), false); err != nil {
+				logger.Warning("Consumer Cancel failed %v", err.Error())
+				this.channel.Close()
+			}
+		}
+
+		// No handler is added once the consume loop stopped.
+		<-this.stopped
+		this.handlerGroup.Wait()
+
+		if this.channel != nil {
+			this.channel.Close()
+		}
+
+		this.session.Close()
+		logger.Warning("Consumer Queue %v Closed", this.queueName)
+	})
+}
+
 func (this *RabbitConsumer) AddCallback(correlationId string, callback chan<- message) {
 	this.gCallbackLock.Lock()
 	this.callbacks[correlationId] = callback
@@ -87,14 +122,36 @@ func (this *RabbitConsumer) initConsumer() error {
 	}
 
 	logger.Warning("Consumer Queue %v Bind %v Success", this.queueName, this.routineKey)
-	go this.subscribe()
 	return nil
 }
 
-func (this *RabbitConsumer) subscribe() error {
+// Consumes until Close, opening the channel again when it is lost. stopped is closed on
+// every way out.
+func (this *RabbitConsumer) run() {
+	defer close(this.stopped)
+
+	for {
+		if deliveries, err := this.subscribe(); err == nil {
+			this.consumeMessage(deliveries)
+		}
+
+		for reconnected := false; !reconnected; {
+			select {
+			case <-this.done:
+				logger.Warning("Consumer Closed.")
+				return
+			case <-time.After(RABBIT_RECONNECT_DELAY):
+				logger.Warning("Consumer Retrying Init...")
+				reconnected = this.initConsumer() == nil
+			}
+		}
+	}
+}
+
+func (this *RabbitConsumer) subscribe() (<-chan amqp.Delivery, error) {
 	deliveries, err := this.channel.Consume(
 		this.queueName,            // name
-		"consumer"+this.queueName, // consumerTag,
+		this.consumerTag(),        // consumerTag,
 		true,  // noAck
 		true,  // exclusive
 		false, // noLocal
@@ -105,16 +162,15 @@ func (this *RabbitConsumer) subscribe() error {
 	if err != nil {
 		logger.Warning("Consume failed %v", err.Error())
 
-		return err
+		return nil, err
 	}
 
 	logger.Warning("Consumer Queue %v Consume Success", this.queueName)
-	go this.consumeMessage(deliveries)
 
-	return nil
+	return deliveries, nil
 }
 
-func (this *RabbitConsumer) consumeMessage(deliveries <-chan amqp.Delivery) bool {
+func (this *RabbitConsumer) consumeMessage(deliveries <-chan amqp.Delivery) {
 	for d := range deliveries {
 		logger.Info("Got %dB Delivery: [%v] %v",
 			len(d.Body), d.DeliveryTag, d.CorrelationId)
@@ -130,24 +186,14 @@ func (this *RabbitConsumer) consumeMessage(deliveries <-chan amqp.Delivery) bool
 
 			this.gCallbackLock.Unlock()
 		} else if this.handler != nil {
-			go this.handler(d)
-		}
-	}
-
-	logger.Warning("Consumer Lost")
+			this.handlerGroup.Add(1)
 
-	for {
-		select {
-		case <-this.done:
-			logger.Warning("Consumer Closed.")
-			return false
-		case <-time.After(RABBIT_RECONNECT_DELAY):
-			logger.Warning("Consumer Retrying Init...")
-			if this.initConsumer() == nil {
-				return true
-			}
+			go func(d amqp.Delivery) {
+				defer this.handlerGroup.Done()
+				this.handler(d)
+			}(d)
 		}
 	}
 
-	return true
+	logger.Warning("Consumer Lost")
 }
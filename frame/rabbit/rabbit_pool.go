@@ -7,29 +7,19 @@ import (
 	"github.com/streadway/amqp"
 )
 
-var (
-	gRabbitSession *RabbitSession
-)
-
 const (
 	RABBIT_INIT_POOL_SIZE = 16
 	RABBIT_MAX_POOL_SIZE  = 256
 )
 
-func NewPool(amqpURI string, initCap int, maxCap int, maxIdle int) pool.Pool {
-	gRabbitSession = NewSession(amqpURI)
-
-	if gRabbitSession == nil {
-		return nil
-	}
-
+func NewPool(session *RabbitSession, initCap int, maxCap int, maxIdle int) (pool.Pool, error) {
 	factory := func() (interface{}, error) {
-		if gRabbitSession.Connection() == nil || gRabbitSession.Connection().IsClosed() {
+		if session.Connection() == nil || session.Connection().IsClosed() {
 			return nil, errors.New("Connection Lost")
 		}
 
 		logger.Info("Create Rabbit Channel")
-		ch := gRabbitSession.Channel()
+		ch := session.Channel()
 
 		if ch == nil {
 			return nil, errors.New("Connection Lost")
@@ -44,7 +34,7 @@ func NewPool(amqpURI string, initCap int, maxCap int, maxIdle int) pool.Pool {
 	}
 
 	ping := func(v interface{}) error {
-		if gRabbitSession.IsChannelValid(v.(*amqp.Channel)) {
+		if session.IsChannelValid(v.(*amqp.Channel)) {
 			return nil
 		} else {
 			return errors.New("Channel Invalid")
@@ -73,11 +63,5 @@ func NewPool(amqpURI string, initCap int, maxCap int, maxIdle int) pool.Pool {
 		IdleTimeout: 0,
 	}
 
-	pool, err := pool.NewChannelPool(poolConfig)
-
-	if err != nil {
-		panic(err)
-	}
-
-	return pool
+	return pool.NewChannelPool(poolConfig)
 }
@@ -1,48 +1,83 @@
 package rabbit
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/derekyu332/goii/helper/logger"
 	"github.com/silenceper/pool"
 	"github.com/streadway/amqp"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+const (
+	DEFAULT_PRODUCER = "default"
+)
+
 var (
-	gProducerPool pool.Pool
+	gProducers    = make(map[string]*RabbitProducer)
+	gProducerLock sync.RWMutex
 	gConsumer     *RabbitConsumer
 	gCorrId       int64
 )
 
+type RabbitProducer struct {
+	session *RabbitSession
+	pool    pool.Pool
+}
+
 func InitProducer(amqpURI string, initCap int, maxCap int, maxIdle int) error {
-	gProducerPool = NewPool(amqpURI, initCap, maxCap, maxIdle)
+	return InitNamedProducer(DEFAULT_PRODUCER, amqpURI, initCap, maxCap, maxIdle)
+}
 
-	if gProducerPool == nil {
-		return errors.New("Unexpected error")
+func InitNamedProducer(name string, amqpURI string, initCap int, maxCap int, maxIdle int) error {
+	session, err := NewSession(amqpURI)
+
+	if err != nil {
+		return err
 	}
 
-	logger.Warning("Rabbit Producer Init Success")
+	channelPool, err := NewPool(session, initCap, maxCap, maxIdle)
 
-	return nil
-}
+	if err != nil {
+		session.Close()
+		return err
+	}
 
-func InitConsumer(amqpURI string, queueName string, key string) error {
-	gConsumer = NewConsumer(amqpURI, queueName, key, nil)
+	gProducerLock.Lock()
+	defer gProducerLock.Unlock()
 
-	if gConsumer == nil {
-		return errors.New("Unexpected error")
+	if old, ok := gProducers[name]; ok {
+		old.Close()
 	}
 
-	gCorrId = time.Now().Unix()
-	logger.Warning("Rabbit Consumer Init Success")
+	gProducers[name] = &RabbitProducer{session: session, pool: channelPool}
+	logger.Warning("Rabbit Producer %v Init Success", name)
 
 	return nil
 }
 
-func GetProducerChannel() *amqp.Channel {
-	ch, err := gProducerPool.Get()
+func GetProducer(name string) *RabbitProducer {
+	gProducerLock.RLock()
+	defer gProducerLock.RUnlock()
+
+	return gProducers[name]
+}
+
+func (this *RabbitProducer) Close() {
+	this.pool.Release()
+	this.session.Close()
+}
+
+func (this *RabbitProducer) IsConnected() bool {
+	return this.session.Connection() != nil && !this.session.Connection().IsClosed()
+}
+
+func (this *RabbitProducer) Channel() *amqp.Channel {
+	ch, err := this.pool.Get()
 
 	if err != nil {
 		logger.Warning("Get Channel Failed %v", err.Error())
@@ -58,6 +93,100 @@ func GetProducerChannel() *amqp.Channel {
 	}
 }
 
+func (this *RabbitProducer) Put(ch *amqp.Channel) error {
+	return this.pool.Put(ch)
+}
+
+func (this *RabbitProducer) Notify(message []byte, routineKey string) error {
+	ch := this.Channel()
+
+	if ch == nil {
+		return errors.New("Unexpected error")
+	}
+
+	defer this.Put(ch)
+
+	if err := ch.Publish(
+		"amq.direct",
+		routineKey,
+		false,
+		false,
+		amqp.Publishing{
+			Headers:         amqp.Table{},
+			ContentType:     "text/plain",
+			ContentEncoding: "",
+			Body:            message,
+			DeliveryMode:    amqp.Transient, // 1=non-persistent, 2=persistent
+			Priority:        0,              // 0-9
+		},
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func InitConsumer(amqpURI string, queueName string, key string) error {
+	var err error
+	gConsumer, err = NewConsumer(amqpURI, queueName, key, nil)
+
+	if err != nil {
+		return err
+	}
+
+	gCorrId = time.Now().Unix()
+	logger.Warning("Rabbit Consumer Init Success")
+
+	return nil
+}
+
+func Close() {
+	if gConsumer != nil {
+		gConsumer.Close()
+		gConsumer = nil
+	}
+
+	gProducerLock.Lock()
+	defer gProducerLock.Unlock()
+
+	for name, producer := range gProducers {
+		producer.Close()
+		delete(gProducers, name)
+		logger.Warning("Rabbit Producer %v Closed", name)
+	}
+}
+
+func Ping(ctx context.Context) error {
+	gProducerLock.RLock()
+	defer gProducerLock.RUnlock()
+
+	if len(gProducers) == 0 {
+		return errors.New("Producer not initialized")
+	}
+
+	for name, producer := range gProducers {
+		if !producer.IsConnected() {
+			return fmt.Errorf("Producer %v Connection Lost", name)
+		}
+	}
+
+	if gConsumer != nil && (gConsumer.Connection() == nil || gConsumer.Connection().IsClosed()) {
+		return errors.New("Consumer Connection Lost")
+	}
+
+	return nil
+}
+
+func GetProducerChannel() *amqp.Channel {
+	producer := GetProducer(DEFAULT_PRODUCER)
+
+	if producer == nil {
+		return nil
+	}
+
+	return producer.Channel()
+}
+
 func RPC(body []byte, routineKey string, timeout time.Duration) ([]byte, error) {
 	if gConsumer == nil || gConsumer.Connection() == nil || gConsumer.Connection().IsClosed() {
 		return nil, errors.New("Unexpected error")
@@ -68,13 +197,19 @@ func RPC(body []byte, routineKey string, timeout time.Duration) ([]byte, error)
 	CorrelationId := strconv.FormatInt(curCorrId, 10)
 	gConsumer.AddCallback(CorrelationId, callback)
 	defer gConsumer.DelCallback(CorrelationId)
-	ch := GetProducerChannel()
+	producer := GetProducer(DEFAULT_PRODUCER)
+
+	if producer == nil {
+		return nil, errors.New("Unexpected error")
+	}
+
+	ch := producer.Channel()
 
 	if ch == nil {
 		return nil, errors.New("Unexpected error")
 	}
 
-	defer gProducerPool.Put(ch)
+	defer producer.Put(ch)
 
 	if err := ch.Publish(
 		"amq.direct",
@@ -107,30 +242,15 @@ func RPC(body []byte, routineKey string, timeout time.Duration) ([]byte, error)
 }
 
 func Notify(message []byte, routineKey string) error {
-	ch := GetProducerChannel()
-
-	if ch == nil {
-		return errors.New("Unexpected error")
-	}
+	return NotifyTo(DEFAULT_PRODUCER, message, routineKey)
+}
 
-	defer gProducerPool.Put(ch)
+func NotifyTo(name string, message []byte, routineKey string) error {
+	producer := GetProducer(name)
 
-	if err := ch.Publish(
-		"amq.direct",
-		routineKey,
-		false,
-		false,
-		amqp.Publishing{
-			Headers:         amqp.Table{},
-			ContentType:     "text/plain",
-			ContentEncoding: "",
-			Body:            message,
-			DeliveryMode:    amqp.Transient, // 1=non-persistent, 2=persistent
-			Priority:        0,              // 0-9
-		},
-	); err != nil {
-		return err
+	if producer == nil {
+		return errors.New("Unexpected error")
 	}
 
-	return nil
+	return producer.Notify(message, routineKey)
 }
@@ -3,6 +3,7 @@ package rabbit
 import (
 	"github.com/derekyu332/goii/helper/logger"
 	"github.com/streadway/amqp"
+	"sync"
 	"time"
 )
 
@@ -15,9 +16,10 @@ type RabbitSession struct {
 	connection *amqp.Connection
 	done       chan bool
 	channels   []*amqp.Channel
+	closeOnce  sync.Once
 }
 
-func NewSession(amqpURI string) *RabbitSession {
+func NewSession(amqpURI string) (*RabbitSession, error) {
 	logger.Warning("Try Connect...")
 	var err error
 	newConnection := &RabbitSession{
@@ -28,13 +30,13 @@ func NewSession(amqpURI string) *RabbitSession {
 	newConnection.connection, err = amqp.Dial(newConnection.uri)
 
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	logger.Warning("Connect Success")
 	go newConnection.handelCheck()
 
-	return newConnection
+	return newConnection, nil
 }
 
 func (this *RabbitSession) Connection() *amqp.Connection {
@@ -70,13 +72,28 @@ func (this *RabbitSession) IsChannelValid(ch *amqp.Channel) bool {
 }
 
 func (this *RabbitSession) Close() {
-	close(this.done)
+	this.closeOnce.Do(func() {
+		close(this.done)
+
+		if this.connection != nil && !this.connection.IsClosed() {
+			if err := this.connection.Close(); err != nil {
+				logger.Warning("Connection Close failed %v", err.Error())
+			}
+		}
+	})
 }
 
 func (this *RabbitSession) handelCheck() {
 	for {
 		select {
 		case <-this.connection.NotifyClose(make(chan *amqp.Error)):
+			select {
+			case <-this.done:
+				logger.Warning("Connection Closed")
+				return
+			default:
+			}
+
 			logger.Warning("Connection Lost. ReConnect...")
 			this.handelReconnect()
 		case <-this.done:
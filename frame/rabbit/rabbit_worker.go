@@ -1,19 +1,19 @@
 package rabbit
 
-import (
-	"errors"
-)
-
 var (
 	gService *RabbitConsumer
 )
 
 func InitWorker(amqpURI string, queueName string, key string, handler RabbitHandler) error {
-	gService = NewConsumer(amqpURI, queueName, key, handler)
+	var err error
+	gService, err = NewConsumer(amqpURI, queueName, key, handler)
 
-	if gService == nil {
-		return errors.New("Unexpected error")
-	}
+	return err
+}
 
-	return nil
+func CloseWorker() {
+	if gService != nil {
+		gService.Close()
+		gService = nil
+	}
 }
@@ -0,0 +1,308 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/helper/logger"
+	"github.com/gomodule/redigo/redis"
+	"math"
+	"time"
+)
+
+// Sets the ttl of counters created by the increment, all in one step.
+var gCounterScript = redis.NewScript(1, `
+local value = redis.call("INCRBY", KEYS[1], ARGV[1])
+if redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return value`)
+
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+type RedisPipeline struct {
+	model *RedisModel
+	data  base.IActiveRecord
+	cmds  []string
+	args  [][]interface{}
+}
+
+func keyOf(data base.IActiveRecord, id interface{}) string {
+	return fmt.Sprintf("%v:%v", data.TableName(), id)
+}
+
+// Runs cmd on the key of data and id, logged and profiled as op.
+func (this *RedisModel) command(data base.IActiveRecord, id interface{}, op string, cmd string,
+	args ...interface{}) (interface{}, error) {
+	req_start := time.Now().UnixNano() / int64(time.Millisecond)
+	session := this.poolOf(data).Get()
+	defer session.Close()
+	key := keyOf(data, id)
+	reply, err := this.do(session, cmd, append([]interface{}{key}, args...)...)
+
+	if err != nil {
+		logger.Error("[%v] %v %v failed %v", this.LogID(), cmd, key, err)
+	} else {
+		logger.Notice("[%v] %v %v success", this.LogID(), cmd, key)
+	}
+
+	return reply, this.LOG_RET_ERR(data.TableName(), req_start, op, key, err)
+}
+
+func (this *RedisModel) ZAdd(data base.IActiveRecord, id interface{}, score float64, member interface{}) error {
+	_, err := this.command(data, id, "ZAdd", "ZADD", score, member)
+
+	return err
+}
+
+// Members ranked start to stop with their scores, highest first when reverse.
+func (this *RedisModel) ZRange(data base.IActiveRecord, id interface{}, start int, stop int,
+	reverse bool) ([]ZMember, error) {
+	cmd := "ZRANGE"
+
+	if reverse {
+		cmd = "ZREVRANGE"
+	}
+
+	values, err := redis.Values(this.command(data, id, "ZRange", cmd, start, stop, "WITHSCORES"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]ZMember, 0, len(values)/2)
+
+	for i := 0; i+1 < len(values); i += 2 {
+		member, _ := redis.String(values[i], nil)
+		score, err := redis.Float64(values[i+1], nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, ZMember{Member: member, Score: score})
+	}
+
+	return members, nil
+}
+
+// Rank of member with the highest score first, -1 when member is not in the set.
+func (this *RedisModel) ZRevRank(data base.IActiveRecord, id interface{}, member interface{}) (int, error) {
+	rank, err := redis.Int(this.command(data, id, "ZRevRank", "ZREVRANK", member))
+
+	if err == redis.ErrNil {
+		return -1, nil
+	}
+
+	return rank, err
+}
+
+func (this *RedisModel) ZIncrBy(data base.IActiveRecord, id interface{}, increment float64,
+	member interface{}) (float64, error) {
+	return redis.Float64(this.command(data, id, "ZIncrBy", "ZINCRBY", increment, member))
+}
+
+// Returns the length of the list after the push.
+func (this *RedisModel) LPush(data base.IActiveRecord, id interface{}, values ...interface{}) (int, error) {
+	return redis.Int(this.command(data, id, "LPush", "LPUSH", values...))
+}
+
+// Found is false when the list is empty.
+func (this *RedisModel) RPop(data base.IActiveRecord, id interface{}) (string, bool, error) {
+	value, err := redis.String(this.command(data, id, "RPop", "RPOP"))
+
+	if err == redis.ErrNil {
+		return "", false, nil
+	}
+
+	return value, err == nil, err
+}
+
+// Blocks up to timeout, rounded up to a second, for an element. The read deadline of the
+// connection is extended by timeout, so the context only aborts before the command is sent.
+func (this *RedisModel) BRPop(data base.IActiveRecord, id interface{}, timeout time.Duration) (string, bool, error) {
+	req_start := time.Now().UnixNano() / int64(time.Millisecond)
+	key := keyOf(data, id)
+
+	if err := this.Context().Err(); err != nil {
+		logger.Warning("[%v] BRPOP %v aborted %v", this.LogID(), key, err)
+		return "", false, this.LOG_RET_ERR(data.TableName(), req_start, "BRPop", key, err)
+	}
+
+	session := this.poolOf(data).Get()
+	defer session.Close()
+	seconds := int64(math.Max(1, math.Ceil(timeout.Seconds())))
+	values, err := redis.Strings(redis.DoWithTimeout(session,
+		time.Duration(seconds)*time.Second+REDIS_READ_TIME_OUT*time.Second, "BRPOP", key, seconds))
+
+	if err == redis.ErrNil {
+		logger.Notice("[%v] BRPOP %v timeout", this.LogID(), key)
+		return "", false, this.LOG_RET_ERR(data.TableName(), req_start, "BRPop", key, nil)
+	} else if err != nil {
+		logger.Error("[%v] BRPOP %v failed %v", this.LogID(), key, err)
+		return "", false, this.LOG_RET_ERR(data.TableName(), req_start, "BRPop", key, err)
+	} else if len(values) != 2 {
+		err = fmt.Errorf("Unexpected BRPOP reply %v", values)
+		return "", false, this.LOG_RET_ERR(data.TableName(), req_start, "BRPop", key, err)
+	}
+
+	logger.Notice("[%v] BRPOP %v success", this.LogID(), key)
+
+	return values[1], true, this.LOG_RET_ERR(data.TableName(), req_start, "BRPop", key, nil)
+}
+
+func (this *RedisModel) LRange(data base.IActiveRecord, id interface{}, start int, stop int) ([]string, error) {
+	return redis.Strings(this.command(data, id, "LRange", "LRANGE", start, stop))
+}
+
+// Counters expire ttl after they are created, zero ttl keeps them forever.
+func (this *RedisModel) IncrBy(data base.IActiveRecord, id interface{}, n int64, ttl time.Duration) (int64, error) {
+	return this.counter(data, id, "IncrBy", n, ttl)
+}
+
+func (this *RedisModel) DecrBy(data base.IActiveRecord, id interface{}, n int64, ttl time.Duration) (int64, error) {
+	return this.counter(data, id, "DecrBy", -n, ttl)
+}
+
+func (this *RedisModel) counter(data base.IActiveRecord, id interface{}, op string, n int64,
+	ttl time.Duration) (int64, error) {
+	if ttl <= 0 {
+		return redis.Int64(this.command(data, id, op, "INCRBY", n))
+	}
+
+	req_start := time.Now().UnixNano() / int64(time.Millisecond)
+	session := this.poolOf(data).Get()
+	defer session.Close()
+	key := keyOf(data, id)
+	value, err := redis.Int64(gCounterScript.DoContext(this.Context(), session, key, n, ttl.Milliseconds()))
+
+	if err != nil {
+		logger.Error("[%v] %v %v failed %v", this.LogID(), op, key, err)
+	} else {
+		logger.Notice("[%v] %v %v success", this.LogID(), op, key)
+	}
+
+	return value, this.LOG_RET_ERR(data.TableName(), req_start, op, key, err)
+}
+
+// Fields follow the json tags of data like HSet and Save, each value is stored json encoded.
+// Sent as HMSET since the multi field HSET needs redis 4.
+func (this *RedisModel) HMSet(data base.IActiveRecord, id interface{}) error {
+	if tracker, ok := data.(RedisTimeTracker); ok {
+		tracker.SetModified(time.Now())
+	}
+
+	args := redis.Args{}
+
+	for field, value := range base.StructToMap(data, "json") {
+		encoded, err := json.Marshal(value)
+
+		if err != nil {
+			logger.Error("[%v] HMSet %v failed %v", this.LogID(), keyOf(data, id), err)
+			return err
+		}
+
+		args = args.Add(field, encoded)
+	}
+
+	_, err := this.command(data, id, "HMSet", "HMSET", args...)
+
+	return err
+}
+
+// Fills the fields of data from the hash, data is left as is when the hash does not exist.
+// Values that are not json, e.g. written by other clients, are taken as strings.
+func (this *RedisModel) HGetAll(data base.IActiveRecord, id interface{}) (base.IActiveRecord, error) {
+	values, err := redis.StringMap(this.command(data, id, "HGetAll", "HGETALL"))
+
+	if err != nil || len(values) == 0 {
+		return data, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+
+	for field, value := range values {
+		if json.Valid([]byte(value)) {
+			fields[field] = json.RawMessage(value)
+		} else {
+			fields[field], _ = json.Marshal(value)
+		}
+	}
+
+	encoded, _ := json.Marshal(fields)
+
+	if err = json.Unmarshal(encoded, data); err != nil {
+		logger.Warning("[%v] HGETALL %v decode failed %v", this.LogID(), keyOf(data, id), err)
+		return data, err
+	}
+
+	return data, base.RunAfterFind(data)
+}
+
+func (this *RedisModel) SMembers(data base.IActiveRecord, id interface{}) ([]string, error) {
+	return redis.Strings(this.command(data, id, "SMembers", "SMEMBERS"))
+}
+
+// Returns how many members were removed.
+func (this *RedisModel) SRem(data base.IActiveRecord, id interface{}, members ...interface{}) (int, error) {
+	return redis.Int(this.command(data, id, "SRem", "SREM", members...))
+}
+
+func (this *RedisModel) SIsMember(data base.IActiveRecord, id interface{}, member interface{}) (bool, error) {
+	return redis.Bool(this.command(data, id, "SIsMember", "SISMEMBER", member))
+}
+
+// Commands queued on the pipeline are sent in one round trip by Exec, on the connection
+// of data.
+func (this *RedisModel) Pipeline(data base.IActiveRecord) *RedisPipeline {
+	return &RedisPipeline{model: this, data: data}
+}
+
+// Queues cmd on the key of id in the table of the pipeline.
+func (this *RedisPipeline) Send(cmd string, id interface{}, args ...interface{}) *RedisPipeline {
+	this.cmds = append(this.cmds, cmd)
+	this.args = append(this.args, append([]interface{}{keyOf(this.data, id)}, args...))
+
+	return this
+}
+
+// Replies in the order commands were queued, failed commands reply a redis.Error.
+func (this *RedisPipeline) Exec() ([]interface{}, error) {
+	return this.exec("Pipeline")
+}
+
+func (this *RedisPipeline) exec(op string) ([]interface{}, error) {
+	if len(this.cmds) == 0 {
+		return nil, nil
+	}
+
+	req_start := time.Now().UnixNano() / int64(time.Millisecond)
+	session := this.model.poolOf(this.data).Get()
+	defer session.Close()
+	var err error
+
+	for i, cmd := range this.cmds {
+		if err = session.Send(cmd, this.args[i]...); err != nil {
+			break
+		}
+	}
+
+	var values []interface{}
+
+	if err == nil {
+		values, err = redis.Values(this.model.do(session, ""))
+	}
+
+	if err != nil {
+		logger.Error("[%v] %v %v failed %v", this.model.LogID(), op, this.cmds, err)
+	} else {
+		logger.Notice("[%v] %v %v success", this.model.LogID(), op, this.cmds)
+	}
+
+	this.cmds, this.args = nil, nil
+
+	return values, this.model.LOG_RET_ERR(this.data.TableName(), req_start, op, len(values), err)
+}
@@ -1,12 +1,15 @@
 package redis
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/derekyu332/goii/frame/base"
 	"github.com/derekyu332/goii/helper/logger"
 	"github.com/gomodule/redigo/redis"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -19,9 +22,8 @@ const (
 )
 
 var (
-	gRedisPool *redis.Pool
-	gRawUrl    string
-	gPassword  string
+	gRedisPools    = make(map[string]*redis.Pool)
+	gRedisPoolLock sync.RWMutex
 )
 
 type RedisTimeTracker interface {
@@ -36,65 +38,175 @@ type RedisModel struct {
 	base.Model
 }
 
-func InitConnection(url string, passowrd string) {
-	logger.Warning("Try Connect...")
-	gRawUrl = url
-	gPassword = passowrd
-	_, err := redis.DialURL(gRawUrl)
+func InitConnection(url string, passowrd string) error {
+	return InitNamedConnection(base.DEFAULT_CONNECTION, url, passowrd)
+}
+
+func InitNamedConnection(name string, url string, passowrd string) error {
+	logger.Warning("Try Connect %v...", name)
+	c, err := redis.DialURL(url, redis.DialConnectTimeout(REDIS_CONNECT_TIME_OUT*time.Second))
 
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	if gRedisPool != nil {
-		gRedisPool.Close()
-		gRedisPool = nil
+	c.Close()
+
+	gRedisPoolLock.Lock()
+	defer gRedisPoolLock.Unlock()
+
+	if old, ok := gRedisPools[name]; ok {
+		old.Close()
 	}
 
-	logger.Warning("Connect Success")
-}
+	gRedisPools[name] = newPool(url, passowrd)
+	logger.Warning("Connect %v Success", name)
 
-func (this *RedisModel) GetPool() *redis.Pool {
-	if gRedisPool == nil {
-		gRedisPool = &redis.Pool{
-			Dial: func() (redis.Conn, error) {
-				c, err := redis.DialURL(gRawUrl, redis.DialConnectTimeout(REDIS_CONNECT_TIME_OUT*time.Second),
-					redis.DialReadTimeout(REDIS_READ_TIME_OUT*time.Second),
-					redis.DialWriteTimeout(REDIS_WRITE_TIME_OUT*time.Second))
+	return nil
+}
 
-				if err != nil {
-					logger.Error("DialURL %v error %v", gRawUrl, err)
+func newPool(rawUrl string, password string) *redis.Pool {
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.DialURL(rawUrl, redis.DialConnectTimeout(REDIS_CONNECT_TIME_OUT*time.Second),
+				redis.DialReadTimeout(REDIS_READ_TIME_OUT*time.Second),
+				redis.DialWriteTimeout(REDIS_WRITE_TIME_OUT*time.Second))
+
+			if err != nil {
+				logger.Error("DialURL %v error %v", rawUrl, err)
+				return nil, err
+			}
+
+			if password != "" {
+				if _, err := c.Do("AUTH", password); err != nil {
+					logger.Error("AUTH error %v", err)
+					c.Close()
 					return nil, err
 				}
+			}
 
-				if gPassword != "" {
-					if _, err := c.Do("AUTH", gPassword); err != nil {
-						logger.Error("AUTH error %v", err)
-						return nil, err
-					}
-				}
+			return c, err
+		},
+		MaxIdle:     REDIS_MAX_IDLE,
+		IdleTimeout: REDIS_IDLE_TIME_OUT * time.Second,
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
 
-				return c, err
-			},
-			MaxIdle:     REDIS_MAX_IDLE,
-			IdleTimeout: REDIS_IDLE_TIME_OUT * time.Second,
-			TestOnBorrow: func(c redis.Conn, t time.Time) error {
-				if time.Since(t) < time.Minute {
-					return nil
-				}
+			_, err := c.Do("PING")
 
-				_, err := c.Do("PING")
+			if err != nil {
+				logger.Error("check connection error %v", err)
+			}
 
-				if err != nil {
-					logger.Error("check connection error %v", err)
-				}
+			return err
+		},
+	}
+}
+
+func GetNamedPool(name string) *redis.Pool {
+	gRedisPoolLock.RLock()
+	defer gRedisPoolLock.RUnlock()
+
+	return gRedisPools[name]
+}
+
+func poolNames() []string {
+	gRedisPoolLock.RLock()
+	defer gRedisPoolLock.RUnlock()
+
+	names := make([]string, 0, len(gRedisPools))
+
+	for name := range gRedisPools {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func Ping(ctx context.Context) error {
+	names := poolNames()
+
+	if len(names) == 0 {
+		return errors.New("Connection not initialized")
+	}
+
+	for _, name := range names {
+		pool := GetNamedPool(name)
+
+		if pool == nil {
+			continue
+		}
+
+		session := pool.Get()
+		_, err := redis.DoWithTimeout(session, time.Until(deadlineOf(ctx)), "PING")
+		session.Close()
+
+		if err != nil {
+			return fmt.Errorf("%v: %v", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func deadlineOf(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+
+	return time.Now().Add(REDIS_READ_TIME_OUT * time.Second)
+}
+
+func CloseConnection() error {
+	gRedisPoolLock.Lock()
+	defer gRedisPoolLock.Unlock()
+
+	var err error
 
-				return err
+	for name, pool := range gRedisPools {
+		if e := pool.Close(); e != nil {
+			logger.Warning("Connection %v Close failed %v", name, e.Error())
+			err = e
+		}
+
+		delete(gRedisPools, name)
+		logger.Warning("Connection %v Closed", name)
+	}
+
+	return err
+}
+
+func (this *RedisModel) WithContext(ctx context.Context) *RedisModel {
+	this.BindContext(ctx)
+
+	return this
+}
+
+func (this *RedisModel) GetPool() *redis.Pool {
+	return this.poolOf(this.Data)
+}
+
+func (this *RedisModel) poolOf(data interface{}) *redis.Pool {
+	pool := GetNamedPool(base.ConnectionOf(data))
+
+	if pool == nil {
+		return &redis.Pool{
+			Dial: func() (redis.Conn, error) {
+				return nil, errors.New("Connection not initialized")
 			},
 		}
 	}
 
-	return gRedisPool
+	return pool
+}
+
+// Cancelled or expired contexts abort the command, see redis.DoContext.
+func (this *RedisModel) do(session redis.Conn, cmd string, args ...interface{}) (interface{}, error) {
+	return redis.DoContext(session, this.Context(), cmd, args...)
 }
 
 func (this *RedisModel) LOG_RET_ERR(tableName string, tStart int64, op string, id interface{}, err error) error {
@@ -123,25 +235,26 @@ func (this *RedisModel) LOG_RET_ERR(tableName string, tStart int64, op string, i
 
 func (this *RedisModel) HGet(data base.IActiveRecord, id interface{}, hkey string) (base.IActiveRecord, error) {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetPool().Get()
+	session := this.poolOf(data).Get()
 	defer session.Close()
 	key := fmt.Sprintf("%v:%v", data.TableName(), id)
-	jsonBytes, err := redis.Bytes(session.Do("HGET", key, hkey))
+	jsonBytes, err := redis.Bytes(this.do(session, "HGET", key, hkey))
 
 	if jsonBytes != nil && err == nil {
 		err = json.Unmarshal(jsonBytes, data)
 
 		if err != nil {
-			logger.Warning("[%v] HGET %v decode failed %v", this.RequestID, key, err)
+			logger.Warning("[%v] HGET %v decode failed %v", this.LogID(), key, err)
 		} else {
-			logger.Notice("[%v] HGET %v-%v success", this.RequestID, key, hkey)
-			logger.Info("[%v] %v", this.RequestID, data)
+			logger.Notice("[%v] HGET %v-%v success", this.LogID(), key, hkey)
+			logger.Info("[%v] %v", this.LogID(), data)
+			err = base.RunAfterFind(data)
 		}
 	} else if err == redis.ErrNil {
-		logger.Notice("[%v] HGET %v no record", this.RequestID, key)
+		logger.Notice("[%v] HGET %v no record", this.LogID(), key)
 		err = nil
 	} else {
-		logger.Error("[%v] HGET %v failed %v", this.RequestID, key, err)
+		logger.Error("[%v] HGET %v failed %v", this.LogID(), key, err)
 	}
 
 	return data, this.LOG_RET_ERR(data.TableName(), req_start, "HGet", key, err)
@@ -149,7 +262,7 @@ func (this *RedisModel) HGet(data base.IActiveRecord, id interface{}, hkey strin
 
 func (this *RedisModel) HSet(data base.IActiveRecord, id interface{}, hkey string) error {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetPool().Get()
+	session := this.poolOf(data).Get()
 	defer session.Close()
 	key := fmt.Sprintf("%v:%v", data.TableName(), id)
 	if tracker, ok := data.(RedisTimeTracker); ok {
@@ -160,14 +273,14 @@ func (this *RedisModel) HSet(data base.IActiveRecord, id interface{}, hkey strin
 	value, err := json.Marshal(value_map)
 
 	if err != nil {
-		logger.Error("[%v] Save %v failed %v", this.RequestID, key, err)
+		logger.Error("[%v] Save %v failed %v", this.LogID(), key, err)
 		return err
 	}
 
-	if _, err := session.Do("HSET", key, hkey, value); err != nil {
-		logger.Error("[%v] HSET %v failed %v", this.RequestID, key, err)
+	if _, err := this.do(session, "HSET", key, hkey, value); err != nil {
+		logger.Error("[%v] HSET %v failed %v", this.LogID(), key, err)
 	} else {
-		logger.Notice("[%v] HSET %v success", this.RequestID, data)
+		logger.Notice("[%v] HSET %v success", this.LogID(), data)
 	}
 
 	return this.LOG_RET_ERR(data.TableName(), req_start, "HSet", key, err)
@@ -175,13 +288,13 @@ func (this *RedisModel) HSet(data base.IActiveRecord, id interface{}, hkey strin
 
 func (this *RedisModel) Expire(data base.IActiveRecord, id interface{}, expiration int64) error {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetPool().Get()
+	session := this.poolOf(data).Get()
 	defer session.Close()
 	key := fmt.Sprintf("%v:%v", data.TableName(), id)
-	_, err := session.Do("EXPIRE", key, expiration)
+	_, err := this.do(session, "EXPIRE", key, expiration)
 
 	if err != nil {
-		logger.Error("[%v] EXPIRE %v failed %v", this.RequestID, key, err)
+		logger.Error("[%v] EXPIRE %v failed %v", this.LogID(), key, err)
 	}
 
 	return this.LOG_RET_ERR(data.TableName(), req_start, "Expire", key, err)
@@ -189,13 +302,13 @@ func (this *RedisModel) Expire(data base.IActiveRecord, id interface{}, expirati
 
 func (this *RedisModel) SCard(data base.IActiveRecord, id interface{}) (int, error) {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetPool().Get()
+	session := this.poolOf(data).Get()
 	defer session.Close()
 	key := fmt.Sprintf("%v:%v", this.Data.TableName(), id)
-	n, err := redis.Int(session.Do("SCARD", key))
+	n, err := redis.Int(this.do(session, "SCARD", key))
 
 	if err != nil {
-		logger.Error("[%v] SCARD %v failed %v", this.RequestID, key, err)
+		logger.Error("[%v] SCARD %v failed %v", this.LogID(), key, err)
 	}
 
 	return n, this.LOG_RET_ERR(data.TableName(), req_start, "SCard", key, err)
@@ -203,15 +316,15 @@ func (this *RedisModel) SCard(data base.IActiveRecord, id interface{}) (int, err
 
 func (this *RedisModel) SAdd(data base.IActiveRecord, id interface{}, v string) error {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	session := this.GetPool().Get()
+	session := this.poolOf(data).Get()
 	defer session.Close()
 	key := fmt.Sprintf("%v:%v", this.Data.TableName(), id)
-	_, err := session.Do("SADD", key, v)
+	_, err := this.do(session, "SADD", key, v)
 
 	if err != nil {
-		logger.Error("[%v] SADD %v failed %v", this.RequestID, key, err)
+		logger.Error("[%v] SADD %v failed %v", this.LogID(), key, err)
 	} else {
-		logger.Notice("[%v] SADD %v success", this.RequestID, key)
+		logger.Notice("[%v] SADD %v success", this.LogID(), key)
 	}
 
 	return this.LOG_RET_ERR(data.TableName(), req_start, "SAdd", key, err)
@@ -222,30 +335,56 @@ func (this *RedisModel) FindOne(id interface{}) (base.IActiveRecord, error) {
 	session := this.GetPool().Get()
 	defer session.Close()
 	key := fmt.Sprintf("%v:%v", this.Data.TableName(), id)
-	jsonBytes, err := redis.Bytes(session.Do("GET", key))
+	jsonBytes, err := redis.Bytes(this.do(session, "GET", key))
 
 	if jsonBytes != nil && err == nil {
 		err = json.Unmarshal(jsonBytes, this.Data)
 
 		if err != nil {
-			logger.Warning("[%v] Find %v decode failed %v", this.RequestID, key, err)
+			logger.Warning("[%v] Find %v decode failed %v", this.LogID(), key, err)
 		} else {
-			logger.Notice("[%v] Find %v success", this.RequestID, key)
-			logger.Info("[%v] %v", this.RequestID, this.Data)
+			logger.Notice("[%v] Find %v success", this.LogID(), key)
+			logger.Info("[%v] %v", this.LogID(), this.Data)
 			this.Exists = true
+			err = base.RunAfterFind(this.Data)
 		}
 	} else if err == redis.ErrNil {
-		logger.Notice("[%v] Find %v no record", this.RequestID, key)
+		logger.Notice("[%v] Find %v no record", this.LogID(), key)
 		this.Exists = false
 		err = nil
 	} else {
-		logger.Error("[%v] Find %v failed %v", this.RequestID, key, err)
+		logger.Error("[%v] Find %v failed %v", this.LogID(), key, err)
 	}
 
 	return this.Data, this.LOG_RET_ERR(this.Data.TableName(), req_start, "FindOne", id, err)
 }
 
 func (this *RedisModel) Save() error {
+	insert := !this.Exists
+
+	if err := base.RunBeforeValidate(this.Data); err != nil {
+		logger.Warning("[%v] BeforeValidate %v failed %v", this.LogID(), this.Data.GetId(), err.Error())
+		return err
+	}
+
+	if err := this.Validate(); err != nil {
+		logger.Warning("[%v] Validate %v failed %v", this.LogID(), this.Data.GetId(), err.Error())
+		return err
+	}
+
+	if err := base.RunBeforeSave(this.Data, insert); err != nil {
+		logger.Warning("[%v] BeforeSave %v failed %v", this.LogID(), this.Data.GetId(), err.Error())
+		return err
+	}
+
+	if err := this.save(); err != nil {
+		return err
+	}
+
+	return base.RunAfterSave(this.Data, insert)
+}
+
+func (this *RedisModel) save() error {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
 	session := this.GetPool().Get()
 	defer session.Close()
@@ -259,30 +398,30 @@ func (this *RedisModel) Save() error {
 	value, err := json.Marshal(value_map)
 
 	if err != nil {
-		logger.Error("[%v] Save %v failed %v", this.RequestID, this.Data.GetId(), err)
+		logger.Error("[%v] Save %v failed %v", this.LogID(), this.Data.GetId(), err)
 		return err
 	}
 
 	if !this.Exists {
-		n, err := session.Do("SETNX", key, value)
+		n, err := this.do(session, "SETNX", key, value)
 
 		if err != nil {
-			logger.Error("[%v] Save %v failed %v", this.RequestID, this.Data.GetId(), err)
+			logger.Error("[%v] Save %v failed %v", this.LogID(), this.Data.GetId(), err)
 			return err
 		} else if n != int64(1) {
-			logger.Error("[%v] Save %v duplicate", this.RequestID, this.Data.GetId())
+			logger.Error("[%v] Save %v duplicate", this.LogID(), this.Data.GetId())
 			return errors.New("duplicate")
 		} else {
-			logger.Notice("[%v] Insert %v success", this.RequestID, this.Data)
+			logger.Notice("[%v] Insert %v success", this.LogID(), this.Data)
 		}
 	} else {
-		_, err := session.Do("SET", key, value)
+		_, err := this.do(session, "SET", key, value)
 
 		if err != nil {
-			logger.Error("[%v] Save %v failed %v", this.RequestID, this.Data.GetId(), err)
+			logger.Error("[%v] Save %v failed %v", this.LogID(), this.Data.GetId(), err)
 			return err
 		} else {
-			logger.Notice("[%v] Update %v success", this.RequestID, this.Data)
+			logger.Notice("[%v] Update %v success", this.LogID(), this.Data)
 		}
 	}
 
@@ -294,6 +433,19 @@ func (this *RedisModel) Delete() error {
 		return nil
 	}
 
+	if err := base.RunBeforeDelete(this.Data); err != nil {
+		logger.Warning("[%v] BeforeDelete %v failed %v", this.LogID(), this.Data.GetId(), err.Error())
+		return err
+	}
+
+	if err := this.delete(); err != nil {
+		return err
+	}
+
+	return base.RunAfterDelete(this.Data)
+}
+
+func (this *RedisModel) delete() error {
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
 	session := this.GetPool().Get()
 	defer session.Close()
@@ -303,13 +455,13 @@ func (this *RedisModel) Delete() error {
 		tracker.SetModified(time.Now())
 	}
 
-	_, err := session.Do("DEL", key)
+	_, err := this.do(session, "DEL", key)
 
 	if err != nil {
-		logger.Error("[%v] Delete %v failed %v", this.RequestID, this.Data.GetId(), err)
+		logger.Error("[%v] Delete %v failed %v", this.LogID(), this.Data.GetId(), err)
 		return err
 	} else {
-		logger.Notice("[%v] Delete %v success", this.RequestID, this.Data.GetId())
+		logger.Notice("[%v] Delete %v success", this.LogID(), this.Data.GetId())
 	}
 
 	return this.LOG_RET_ERR(this.Data.TableName(), req_start, "Delete", this.Data.GetId(), err)
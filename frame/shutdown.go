@@ -0,0 +1,227 @@
+package frame
+
+import (
+	"context"
+	"errors"
+	"github.com/derekyu332/goii/frame/cache"
+	"github.com/derekyu332/goii/frame/kafka"
+	"github.com/derekyu332/goii/frame/mongo"
+	"github.com/derekyu332/goii/frame/rabbit"
+	"github.com/derekyu332/goii/frame/redis"
+	"github.com/derekyu332/goii/frame/sql"
+	"github.com/derekyu332/goii/frame/worker"
+	"github.com/derekyu332/goii/helper/logger"
+	"time"
+)
+
+const (
+	DEFAULT_SHUTDOWN_TIMEOUT = 10 * time.Second
+	POOL_CLOSE_TIMEOUT       = 3 * time.Second
+)
+
+type ShutdownHook func(ctx context.Context) error
+
+type shutdownEntry struct {
+	name string
+	hook ShutdownHook
+}
+
+func (this *App) OnShutdown(name string, hook ShutdownHook) {
+	this.shutdownLock.Lock()
+	defer this.shutdownLock.Unlock()
+	this.shutdownHooks = append(this.shutdownHooks, shutdownEntry{name: name, hook: hook})
+}
+
+func (this *App) shutdownStep(ctx context.Context, name string, step func(ctx context.Context) error) error {
+	done := make(chan error, 1)
+	req_start := time.Now()
+
+	go func() {
+		done <- step(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			logger.Warning("Shutdown %v failed %v", name, err.Error())
+		} else {
+			logger.Warning("Shutdown %v Success (%v)", name, time.Since(req_start))
+		}
+
+		return err
+	case <-ctx.Done():
+		logger.Warning("Shutdown %v timeout", name)
+		return ctx.Err()
+	}
+}
+
+func (this *App) stopAccepting(ctx context.Context) []error {
+	var errs []error
+
+	if this.httpServer != nil {
+		errs = append(errs, this.shutdownStep(ctx, "HttpServer", func(ctx context.Context) error {
+			return this.httpServer.Shutdown(ctx)
+		}))
+	}
+
+	if this.server != nil {
+		errs = append(errs, this.shutdownStep(ctx, "RpcServer", func(ctx context.Context) error {
+			stopped := make(chan bool)
+
+			go func() {
+				this.server.GracefulStop()
+				close(stopped)
+			}()
+
+			select {
+			case <-stopped:
+				return nil
+			case <-ctx.Done():
+				this.server.Stop()
+				return ctx.Err()
+			}
+		}))
+	}
+
+	return errs
+}
+
+func (this *App) stopConsumers(ctx context.Context) []error {
+	var errs []error
+
+	if this.WorkerInit != nil {
+		errs = append(errs, this.shutdownStep(ctx, "WorkerPool", func(ctx context.Context) error {
+			worker.StopPool()
+			return nil
+		}))
+	}
+
+	if this.RabbitInit != nil && this.RabbitInit.OpenWorker {
+		errs = append(errs, this.shutdownStep(ctx, "RabbitWorker", func(ctx context.Context) error {
+			rabbit.CloseWorker()
+			return nil
+		}))
+	}
+
+	if this.KafkaInit != nil {
+		errs = append(errs, this.shutdownStep(ctx, "KafkaConsumer", func(ctx context.Context) error {
+			kafka.CloseWorker()
+			return nil
+		}))
+	}
+
+	if len(this.mongoConfigs()) > 0 {
+		errs = append(errs, this.shutdownStep(ctx, "MongoWatchers", func(ctx context.Context) error {
+			mongo.CloseWatchers()
+			return nil
+		}))
+	}
+
+	if this.subscriber != nil {
+		errs = append(errs, this.shutdownStep(ctx, "EtherSubscriber", func(ctx context.Context) error {
+			this.subscriber.Close()
+			return nil
+		}))
+	}
+
+	return errs
+}
+
+func (this *App) runShutdownHooks(ctx context.Context) []error {
+	this.shutdownLock.Lock()
+	hooks := make([]shutdownEntry, len(this.shutdownHooks))
+	copy(hooks, this.shutdownHooks)
+	this.shutdownLock.Unlock()
+	var errs []error
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		errs = append(errs, this.shutdownStep(ctx, hooks[i].name, hooks[i].hook))
+	}
+
+	return errs
+}
+
+func (this *App) closePools(ctx context.Context) []error {
+	var errs []error
+
+	if len(this.rabbitConfigs()) > 0 {
+		errs = append(errs, this.shutdownStep(ctx, "Rabbit", func(ctx context.Context) error {
+			rabbit.Close()
+			return nil
+		}))
+	}
+
+	if len(this.sqlConfigs()) > 0 {
+		errs = append(errs, this.shutdownStep(ctx, "Sql", func(ctx context.Context) error {
+			return sql.CloseEngine()
+		}))
+	}
+
+	if len(this.mongoConfigs()) > 0 {
+		errs = append(errs, this.shutdownStep(ctx, "Mongo", func(ctx context.Context) error {
+			mongo.CloseConnection()
+			return nil
+		}))
+	}
+
+	if len(this.redisConfigs()) > 0 {
+		errs = append(errs, this.shutdownStep(ctx, "Redis", func(ctx context.Context) error {
+			cache.StopBus()
+			return redis.CloseConnection()
+		}))
+	}
+
+	return errs
+}
+
+func (this *App) Shutdown(ctx context.Context) error {
+	err := errors.New("Shutdown already in progress")
+
+	this.shutdownOnce.Do(func() {
+		logger.Warning("Application Shutdown...")
+		this.setReady(false)
+
+		if this.healthServer != nil {
+			if this.healthDone != nil {
+				close(this.healthDone)
+			}
+
+			this.healthServer.Shutdown()
+		}
+
+		var errs []error
+		errs = append(errs, this.stopAccepting(ctx)...)
+		errs = append(errs, this.stopConsumers(ctx)...)
+		errs = append(errs, this.runShutdownHooks(ctx)...)
+		errs = append(errs, this.stopComponents(ctx)...)
+
+		// Pools are closed on their own budget, earlier steps may have used up ctx.
+		pool_ctx, cancel := context.WithTimeout(context.Background(), POOL_CLOSE_TIMEOUT)
+		errs = append(errs, this.closePools(pool_ctx)...)
+		cancel()
+		err = nil
+
+		for _, e := range errs {
+			if e != nil {
+				err = e
+				break
+			}
+		}
+
+		if err != nil {
+			logger.Warning("Application Shutdown failed %v", err.Error())
+		} else {
+			logger.Warning("Application Shutdown Success")
+		}
+	})
+
+	return err
+}
+
+func (this *App) shutdownTimeout() time.Duration {
+	if this.ShutdownTimeout > 0 {
+		return this.ShutdownTimeout
+	}
+
+	return DEFAULT_SHUTDOWN_TIMEOUT
+}
@@ -0,0 +1,144 @@
+package sql
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/derekyu332/goii/helper/logger"
+	"time"
+	"xorm.io/xorm"
+)
+
+const (
+	AUDIT_TABLE  = "audit_logs"
+	AUDIT_INSERT = "insert"
+	AUDIT_UPDATE = "update"
+	AUDIT_UPSERT = "upsert"
+	AUDIT_DELETE = "delete"
+)
+
+type TableSoftDeleter interface {
+	SoftDeleteAttr() string
+}
+
+type TableAuditor interface {
+	AuditIgnore() []string
+}
+
+type AuditLog struct {
+	Id        int64     `xorm:"pk autoincr 'id'" sql:"id"`
+	Table     string    `xorm:"varchar(64) index 'record_table'" sql:"record_table"`
+	RecordId  string    `xorm:"varchar(128) index 'record_id'" sql:"record_id"`
+	Op        string    `xorm:"varchar(16) 'op'" sql:"op"`
+	Old       string    `xorm:"text 'old_values'" sql:"old_values"`
+	New       string    `xorm:"text 'new_values'" sql:"new_values"`
+	RequestID int64     `xorm:"'request_id'" sql:"request_id"`
+	Operator  string    `xorm:"varchar(128) 'operator'" sql:"operator"`
+	Created   time.Time `xorm:"'created'" sql:"created"`
+}
+
+func (this *AuditLog) GetId() interface{} {
+	return this.Id
+}
+
+func (this *AuditLog) TableName() string {
+	return AUDIT_TABLE
+}
+
+func (this *SqlModel) scope(session *xorm.Session) *xorm.Session {
+	if deleter, ok := this.Data.(TableSoftDeleter); ok && !this.WithDeleted {
+		return session.And(deleter.SoftDeleteAttr() + " IS NULL")
+	}
+
+	return session
+}
+
+func (this *SqlModel) auditLog(op string, before map[string]interface{}, after map[string]interface{}) *AuditLog {
+	auditor, ok := this.Data.(TableAuditor)
+
+	if !ok {
+		return nil
+	}
+
+	for _, name := range auditor.AuditIgnore() {
+		delete(before, name)
+		delete(after, name)
+	}
+
+	log := &AuditLog{
+		Table:     this.Data.TableName(),
+		RecordId:  fmt.Sprintf("%v", this.Data.GetId()),
+		Op:        op,
+		RequestID: this.RequestID,
+		Operator:  this.Operator,
+		Created:   time.Now(),
+	}
+
+	if before != nil {
+		data, _ := json.Marshal(before)
+		log.Old = string(data)
+	}
+
+	if after != nil {
+		data, _ := json.Marshal(after)
+		log.New = string(data)
+	}
+
+	return log
+}
+
+// Creates or updates the audit table of the connection, run at startup for SqlConfig.Audit.
+func SyncAuditTable(name string) error {
+	engine := GetNamedEngine(name)
+
+	if engine == nil {
+		return fmt.Errorf("Engine %v not initialized", name)
+	}
+
+	if err := engine.Sync2(new(AuditLog)); err != nil {
+		logger.Error("Sync %v %v failed %v", name, AUDIT_TABLE, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (this *SqlModel) writeAudit(engine xorm.Interface, logs ...*AuditLog) {
+	var records []*AuditLog
+
+	for _, log := range logs {
+		if log != nil {
+			records = append(records, log)
+		}
+	}
+
+	if len(records) == 0 {
+		return
+	}
+
+	if _, err := this.bind(engine, engine.Table(AUDIT_TABLE)).Insert(records); err != nil {
+		logger.Warning("[%v] Audit %v records failed %v", this.LogID(), len(records), err.Error())
+	}
+}
+
+func auditDiff(oldAttr map[string]interface{}, attr map[string]interface{}, cols []string) (map[string]interface{},
+	map[string]interface{}) {
+	before := make(map[string]interface{})
+	after := make(map[string]interface{})
+
+	for _, col := range cols {
+		before[col] = oldAttr[col]
+		after[col] = attr[col]
+	}
+
+	return before, after
+}
+
+func auditCopy(attr map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{})
+
+	for name, value := range attr {
+		copied[name] = value
+	}
+
+	return copied
+}
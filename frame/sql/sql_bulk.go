@@ -0,0 +1,404 @@
+package sql
+
+import (
+	"errors"
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/frame/cache"
+	"github.com/derekyu332/goii/helper/extend"
+	"github.com/derekyu332/goii/helper/logger"
+	"reflect"
+	"time"
+	"xorm.io/xorm"
+)
+
+var (
+	ErrBulkAborted = errors.New("Bulk aborted")
+)
+
+type BulkResult struct {
+	Id  interface{}
+	Err error
+}
+
+// Stale records are reported per item, any other error rolls the whole batch back.
+// Auto increment ids are not read back from a multi-insert.
+func (this *SqlModel) BulkSave(models []*SqlModel) ([]BulkResult, error) {
+	results := make([]BulkResult, len(models))
+
+	if len(models) == 0 {
+		return results, nil
+	}
+
+	var inserted []*SqlModel
+	var updated []*SqlModel
+	saved := make(map[int]bool)
+
+	err := this.bulk("BulkSave", len(models), func(session xorm.Interface) error {
+		var inserts []base.IActiveRecord
+		var logs []*AuditLog
+
+		for i, model := range models {
+			results[i].Id = model.Data.GetId()
+			err := base.RunBeforeValidate(model.Data)
+
+			if err == nil {
+				err = model.Validate()
+			}
+
+			if err == nil {
+				err = base.RunBeforeSave(model.Data, !model.Exists)
+			}
+
+			if err != nil {
+				logger.Warning("[%v] BeforeSave %v failed %v", this.LogID(), results[i].Id, err.Error())
+				results[i].Err = err
+				continue
+			}
+
+			if !model.Exists {
+				inserted = append(inserted, model)
+				inserts = append(inserts, model.Data)
+				saved[i] = true
+				continue
+			}
+
+			dirty_cols := model.GetDirtyCols()
+
+			if len(dirty_cols) <= 0 {
+				continue
+			}
+
+			if err := model.update(session, dirty_cols); err == ErrStaleRecord {
+				results[i].Err = err
+			} else if err != nil {
+				return err
+			} else {
+				before, after := auditDiff(model.oldAttr, base.StructToMap(model.Data, "sql"), dirty_cols)
+				logs = append(logs, model.auditLog(AUDIT_UPDATE, before, after))
+				updated = append(updated, model)
+				saved[i] = false
+			}
+		}
+
+		if err := insertMulti(session, inserts); err != nil {
+			return err
+		}
+
+		for _, model := range inserted {
+			logs = append(logs, model.auditLog(AUDIT_INSERT, nil, base.StructToMap(model.Data, "sql")))
+		}
+
+		this.writeAudit(session, logs...)
+
+		return nil
+	})
+
+	records := make([]base.IActiveRecord, len(models))
+
+	for i, model := range models {
+		records[i] = model.Data
+	}
+
+	cache.InvalidateRecords(this.Context(), records)
+
+	if err != nil {
+		for _, model := range updated {
+			if locker, ok := model.Data.(TableLocker); ok {
+				lock_seq, _ := extend.InterfaceToInt64(model.oldAttr[locker.OptimisticLock()])
+				setLockValue(model.Data, locker.OptimisticLock(), lock_seq)
+			}
+		}
+
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = ErrBulkAborted
+			}
+		}
+
+		return results, err
+	}
+
+	for _, model := range updated {
+		model.RefreshOldAttr()
+	}
+
+	for _, model := range inserted {
+		model.RefreshOldAttr()
+		model.Exists = true
+	}
+
+	for i, model := range models {
+		results[i].Id = model.Data.GetId()
+
+		if insert, ok := saved[i]; ok {
+			results[i].Err = base.RunAfterSave(model.Data, insert)
+		}
+	}
+
+	return results, nil
+}
+
+// Records run the hooks of Save. Soft deleted records are not brought back, they fail
+// with ErrRecordDeleted.
+func (this *SqlModel) BulkUpsert(records []base.IActiveRecord) ([]BulkResult, error) {
+	results := make([]BulkResult, len(records))
+
+	if len(records) == 0 {
+		return results, nil
+	}
+
+	saved := make(map[int]bool)
+
+	err := this.bulk("BulkUpsert", len(records), func(session xorm.Interface) error {
+		var inserts []base.IActiveRecord
+		var logs []*AuditLog
+
+		for i, record := range records {
+			results[i].Id = record.GetId()
+			pk, ok := primaryKeyOf(record)
+
+			if !ok {
+				results[i].Err = errors.New("Unexpected error")
+				continue
+			}
+
+			model := this.recordModel(record)
+			has, err := model.scope(session.Table(record).ID(pk)).Exist()
+
+			if err != nil {
+				return err
+			}
+
+			if _, soft := record.(TableSoftDeleter); soft && !has && !model.WithDeleted {
+				if deleted, err := session.Table(record).ID(pk).Exist(); err != nil {
+					return err
+				} else if deleted {
+					results[i].Err = ErrRecordDeleted
+					continue
+				}
+			}
+
+			err = base.RunBeforeValidate(record)
+
+			if err == nil {
+				err = model.Validate()
+			}
+
+			if err == nil {
+				err = base.RunBeforeSave(record, !has)
+			}
+
+			if err != nil {
+				logger.Warning("[%v] BeforeSave %v failed %v", this.LogID(), results[i].Id, err.Error())
+				results[i].Err = err
+				continue
+			}
+
+			if !has {
+				inserts = append(inserts, record)
+			} else if _, err = model.scope(session.ID(pk).AllCols()).Update(record); err != nil {
+				return err
+			}
+
+			saved[i] = !has
+			logs = append(logs, this.recordAudit(record, AUDIT_UPSERT))
+		}
+
+		if err := insertMulti(session, inserts); err != nil {
+			return err
+		}
+
+		this.writeAudit(session, logs...)
+
+		return nil
+	})
+
+	cache.InvalidateRecords(this.Context(), records)
+
+	if err != nil {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = ErrBulkAborted
+			}
+		}
+
+		return results, err
+	}
+
+	for i, record := range records {
+		if insert, ok := saved[i]; ok {
+			results[i].Err = base.RunAfterSave(record, insert)
+		}
+	}
+
+	return results, nil
+}
+
+func (this *SqlModel) BulkDelete(records []base.IActiveRecord) ([]BulkResult, error) {
+	results := make([]BulkResult, len(records))
+
+	if len(records) == 0 {
+		return results, nil
+	}
+
+	deleter, soft := this.Data.(TableSoftDeleter)
+	err := this.bulk("BulkDelete", len(records), func(session xorm.Interface) error {
+		var logs []*AuditLog
+
+		for i, record := range records {
+			results[i].Id = record.GetId()
+			pk, ok := primaryKeyOf(record)
+
+			if !ok {
+				results[i].Err = errors.New("Unexpected error")
+				continue
+			}
+
+			if err := base.RunBeforeDelete(record); err != nil {
+				logger.Warning("[%v] BeforeDelete %v failed %v", this.LogID(), results[i].Id, err.Error())
+				results[i].Err = err
+				continue
+			}
+
+			var affected int64
+			var err error
+
+			if soft {
+				affected, err = session.Table(record).ID(pk).And(deleter.SoftDeleteAttr() + " IS NULL").
+					Update(map[string]interface{}{
+						deleter.SoftDeleteAttr(): time.Now(),
+					})
+			} else {
+				affected, err = session.ID(pk).Delete(record)
+			}
+
+			if err != nil {
+				return err
+			} else if affected == 0 {
+				logger.Warning("[%v] Delete %v not affected", this.LogID(), pk)
+				results[i].Err = ErrRecordNotFound
+			} else {
+				logs = append(logs, this.recordAudit(record, AUDIT_DELETE))
+			}
+		}
+
+		this.writeAudit(session, logs...)
+
+		return nil
+	})
+
+	cache.InvalidateRecords(this.Context(), records)
+
+	if err != nil {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = ErrBulkAborted
+			}
+		}
+	} else {
+		for i, record := range records {
+			if results[i].Err == nil {
+				results[i].Err = base.RunAfterDelete(record)
+			}
+		}
+	}
+
+	return results, err
+}
+
+func (this *SqlModel) bulk(op string, count int, fn func(session xorm.Interface) error) error {
+	req_start := time.Now().UnixNano() / int64(time.Millisecond)
+	var err error
+
+	if this.tx != nil {
+		err = fn(this.tx.session)
+	} else {
+		group := GetNamedGroup(base.ConnectionOf(this.Data))
+
+		if group == nil {
+			return errors.New("Unexpected error")
+		}
+
+		if len(group.Slaves()) > 0 {
+			StickToMaster(this.RequestID)
+		}
+
+		session := group.Master().NewSession().Context(this.Context())
+		defer session.Close()
+
+		if err = session.Begin(); err == nil {
+			if err = fn(session); err == nil {
+				err = session.Commit()
+			} else {
+				session.Rollback()
+			}
+		}
+	}
+
+	if err != nil {
+		logger.Warning("[%v] %v %v records failed %v", this.LogID(), op, count, err.Error())
+	} else {
+		logger.Notice("[%v] %v %v records success", this.LogID(), op, count)
+	}
+
+	return this.LOG_RET_ERR(this.Data.TableName(), req_start, op, count, err)
+}
+
+// Model of a record handed to a bulk call, sharing the request of this.
+func (this *SqlModel) recordModel(record base.IActiveRecord) *SqlModel {
+	model := &SqlModel{WithDeleted: this.WithDeleted}
+	model.Data = record
+	model.RequestID = this.RequestID
+	model.Operator = this.Operator
+	model.BindContext(this.Context())
+
+	return model
+}
+
+func (this *SqlModel) recordAudit(record base.IActiveRecord, op string) *AuditLog {
+	model := this.recordModel(record)
+
+	if op == AUDIT_DELETE {
+		return model.auditLog(op, base.StructToMap(record, "sql"), nil)
+	}
+
+	return model.auditLog(op, nil, base.StructToMap(record, "sql"))
+}
+
+func insertMulti(session xorm.Interface, records []base.IActiveRecord) error {
+	var types []reflect.Type
+	groups := make(map[reflect.Type]reflect.Value)
+
+	for _, record := range records {
+		t := reflect.TypeOf(record)
+
+		if _, ok := groups[t]; !ok {
+			types = append(types, t)
+			groups[t] = reflect.MakeSlice(reflect.SliceOf(t), 0, len(records))
+		}
+
+		groups[t] = reflect.Append(groups[t], reflect.ValueOf(record))
+	}
+
+	for _, t := range types {
+		if _, err := session.Insert(groups[t].Interface()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func primaryKeyOf(data base.IActiveRecord) (interface{}, bool) {
+	record, ok := data.(ISqlRecord)
+
+	if !ok {
+		return nil, false
+	}
+
+	if len(record.PrimaryKey()) <= 1 {
+		return data.GetId(), true
+	}
+
+	return record.PrimaryKey(), true
+}
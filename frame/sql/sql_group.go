@@ -0,0 +1,105 @@
+package sql
+
+import (
+	"fmt"
+	"github.com/patrickmn/go-cache"
+	"strings"
+	"sync"
+	"time"
+	"xorm.io/xorm"
+)
+
+const (
+	POLICY_RANDOM             = "random"
+	POLICY_WEIGHT_RANDOM      = "weightrandom"
+	POLICY_ROUND_ROBIN        = "roundrobin"
+	POLICY_WEIGHT_ROUND_ROBIN = "weightroundrobin"
+	POLICY_LEAST_CONN         = "leastconn"
+	STICK_TO_MASTER_TIMEOUT   = 5 * time.Second
+)
+
+var (
+	gUnhealthy     sync.Map
+	gStickToMaster = cache.New(STICK_TO_MASTER_TIMEOUT, time.Minute)
+)
+
+// Weighted policies need one weight above zero for each of the slaves.
+func GroupPolicy(name string, weights []int, slaves int) (xorm.GroupPolicy, error) {
+	switch strings.ToLower(name) {
+	case POLICY_WEIGHT_RANDOM, POLICY_WEIGHT_ROUND_ROBIN:
+		if len(weights) != slaves {
+			return nil, fmt.Errorf("policy %v needs %v weights, got %v", name, slaves, len(weights))
+		}
+
+		for _, weight := range weights {
+			if weight <= 0 {
+				return nil, fmt.Errorf("policy %v weight %v must be positive", name, weight)
+			}
+		}
+	}
+
+	switch strings.ToLower(name) {
+	case "", POLICY_ROUND_ROBIN:
+		return xorm.RoundRobinPolicy(), nil
+	case POLICY_RANDOM:
+		return xorm.RandomPolicy(), nil
+	case POLICY_WEIGHT_RANDOM:
+		return xorm.WeightRandomPolicy(weights), nil
+	case POLICY_WEIGHT_ROUND_ROBIN:
+		return xorm.WeightRoundRobinPolicy(weights), nil
+	case POLICY_LEAST_CONN:
+		return xorm.LeastConnPolicy(), nil
+	}
+
+	return nil, fmt.Errorf("unknown policy %v", name)
+}
+
+func setHealthy(engine *xorm.Engine, healthy bool) {
+	if healthy {
+		gUnhealthy.Delete(engine)
+	} else {
+		gUnhealthy.Store(engine, true)
+	}
+}
+
+func isHealthy(engine *xorm.Engine) bool {
+	_, unhealthy := gUnhealthy.Load(engine)
+
+	return !unhealthy
+}
+
+func forgetGroup(group *xorm.EngineGroup) {
+	for _, slave := range group.Slaves() {
+		gUnhealthy.Delete(slave)
+	}
+}
+
+func healthySlave(group *xorm.EngineGroup) *xorm.Engine {
+	if slave := group.Slave(); isHealthy(slave) {
+		return slave
+	}
+
+	for _, slave := range group.Slaves() {
+		if isHealthy(slave) {
+			return slave
+		}
+	}
+
+	return group.Master()
+}
+
+func StickToMaster(requestID int64) {
+	if requestID != 0 {
+		gStickToMaster.SetDefault(fmt.Sprintf("%v", requestID), true)
+	}
+}
+
+func IsStickToMaster(requestID int64) bool {
+	if requestID == 0 {
+		return false
+	}
+
+	_, found := gStickToMaster.Get(fmt.Sprintf("%v", requestID))
+
+	return found
+}
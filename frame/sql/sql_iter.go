@@ -0,0 +1,229 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/frame/query"
+	"github.com/derekyu332/goii/helper/logger"
+	"reflect"
+	"strings"
+	"time"
+	"xorm.io/xorm"
+)
+
+type SqlIterator struct {
+	ctx       context.Context
+	model     *SqlModel
+	cond      interface{}
+	rows      *xorm.Rows
+	current   *SqlModel
+	count     int
+	err       error
+	closed    bool
+	req_start int64
+}
+
+// Cancellation of ctx is checked between records.
+func (this *SqlModel) Iterate(ctx context.Context, selector FindAllSelector) (*SqlIterator, error) {
+	if err := this.checkSelector(selector); err != nil {
+		return nil, err
+	}
+
+	engine := this.readEngine()
+
+	if engine == nil {
+		return nil, errors.New("Unexpected error")
+	}
+
+	if ctx == nil {
+		ctx = this.Context()
+	}
+
+	req_start := time.Now().UnixNano() / int64(time.Millisecond)
+	session := this.scope(selectorSession(engine, selector))
+
+	if this.tx == nil {
+		session = session.Context(ctx)
+	}
+
+	if selector.Cond != "" {
+		session = session.Where(selector.Cond)
+	}
+
+	if selector.Page >= 0 && selector.Limit > 0 {
+		session = session.Limit(selector.Limit, selector.Page*selector.Limit)
+	}
+
+	rows, err := session.Rows(this.Data)
+
+	if err != nil {
+		logger.Error("[%v] Iterate %v failed %v", this.LogID(), selectorCond(selector), err)
+
+		return nil, this.LOG_RET_ERR(this.Data.TableName(), req_start, "Iterate", selectorCond(selector), err)
+	}
+
+	return &SqlIterator{
+		ctx:       ctx,
+		model:     this,
+		cond:      selectorCond(selector),
+		rows:      rows,
+		req_start: req_start,
+	}, nil
+}
+
+func (this *SqlModel) Each(ctx context.Context, selector FindAllSelector, fn func(model *SqlModel) error) error {
+	iter, err := this.Iterate(ctx, selector)
+
+	if err != nil {
+		return err
+	}
+
+	for iter.Next() {
+		if err = fn(iter.Model()); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+
+	return iter.Close()
+}
+
+// Key must be a unique column, prefix it with "-" for descending order. A nil after returns the first page.
+func (this *SqlModel) FindAfter(result interface{}, key string, after interface{}, selector FindAllSelector) error {
+	engine := this.readEngine()
+
+	if engine == nil {
+		return errors.New("Unexpected error")
+	}
+
+	req_start := time.Now().UnixNano() / int64(time.Millisecond)
+	column := strings.TrimPrefix(key, "-")
+
+	if err := query.ValidateFields(this.Data, "sql", column); err != nil {
+		logger.Warning("[%v] FindAfter key %v invalid %v", this.LogID(), key, err.Error())
+		return err
+	}
+
+	op := " > ?"
+	selector.Asc = column
+	selector.Desc = ""
+
+	if strings.HasPrefix(key, "-") {
+		op = " < ?"
+		selector.Asc = ""
+		selector.Desc = column
+	}
+
+	if selector.Query != nil {
+		selector.Query = query.Where(selector.Query.Cond()).Select(selector.Query.Fields()...)
+	}
+
+	if err := this.checkSelector(selector); err != nil {
+		return err
+	}
+
+	session := this.bind(engine, this.scope(selectorSession(engine, selector)))
+
+	if selector.Cond != "" {
+		session = session.Where(selector.Cond)
+	}
+
+	if after != nil {
+		session = session.And(query.Quote(column)+op, after)
+	}
+
+	if selector.Limit > 0 {
+		session = session.Limit(selector.Limit)
+	}
+
+	err := session.Find(result)
+
+	if err == nil {
+		err = base.RunAfterFindAll(result)
+	}
+
+	if err != nil {
+		logger.Error("[%v] FindAfter %v %v failed %v", this.LogID(), selectorCond(selector), after, err)
+	} else {
+		logger.Notice("[%v] FindAfter %v %v Success.", this.LogID(), selectorCond(selector), after)
+	}
+
+	return this.LOG_RET_ERR(this.Data.TableName(), req_start, "FindAfter", selectorCond(selector), err)
+}
+
+func (this *SqlIterator) Next() bool {
+	if this.closed || this.err != nil {
+		return false
+	}
+
+	if err := this.ctx.Err(); err != nil {
+		this.err = err
+		return false
+	}
+
+	if !this.rows.Next() {
+		return false
+	}
+
+	data, ok := reflect.New(reflect.TypeOf(this.model.Data).Elem()).Interface().(base.IActiveRecord)
+
+	if !ok {
+		this.err = errors.New("Unexpected error")
+		return false
+	}
+
+	if err := this.rows.Scan(data); err != nil {
+		this.err = err
+		return false
+	}
+
+	if err := base.RunAfterFind(data); err != nil {
+		this.err = err
+		return false
+	}
+
+	model := &SqlModel{UseMaster: this.model.UseMaster, tx: this.model.tx}
+	model.RequestID = this.model.RequestID
+	model.Data = data
+	model.Exists = true
+	model.RefreshOldAttr()
+	this.current = model
+	this.count++
+
+	return true
+}
+
+func (this *SqlIterator) Model() *SqlModel {
+	return this.current
+}
+
+func (this *SqlIterator) Err() error {
+	if this.err != nil {
+		return this.err
+	}
+
+	return this.rows.Err()
+}
+
+func (this *SqlIterator) Close() error {
+	if this.closed {
+		return this.Err()
+	}
+
+	this.closed = true
+
+	if err := this.rows.Close(); err != nil && this.err == nil {
+		this.err = err
+	}
+
+	err := this.Err()
+
+	if err != nil {
+		logger.Error("[%v] Iterate %v failed %v", this.model.LogID(), this.cond, err)
+	} else {
+		logger.Notice("[%v] Iterate %v %v records Success.", this.model.LogID(), this.cond, this.count)
+	}
+
+	return this.model.LOG_RET_ERR(this.model.Data.TableName(), this.req_start, "Iterate", this.cond, err)
+}
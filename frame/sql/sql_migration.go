@@ -0,0 +1,163 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/helper/logger"
+	"time"
+)
+
+const (
+	MIGRATION_TABLE = "migrations"
+)
+
+var (
+	gMigrations base.MigrationRegistry[*Migration]
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *Tx) error
+	Down    func(tx *Tx) error
+}
+
+func (this *Migration) MigrationVersion() int64 {
+	return this.Version
+}
+
+func (this *Migration) MigrationName() string {
+	return this.Name
+}
+
+type MigrationStatus = base.MigrationStatus
+
+type migrationRecord struct {
+	Version   int64     `xorm:"pk 'version'"`
+	Name      string    `xorm:"varchar(255) notnull 'name'"`
+	AppliedAt time.Time `xorm:"'applied_at'"`
+}
+
+func (this *migrationRecord) TableName() string {
+	return MIGRATION_TABLE
+}
+
+func RegisterMigration(migrations ...*Migration) error {
+	return RegisterNamedMigration(base.DEFAULT_CONNECTION, migrations...)
+}
+
+// Rejects versions already registered on the connection.
+func RegisterNamedMigration(name string, migrations ...*Migration) error {
+	err := gMigrations.Register(name, migrations...)
+
+	if err != nil {
+		logger.Error("Register migration failed %v", err.Error())
+	}
+
+	return err
+}
+
+func appliedMigrations(name string) (map[int64]time.Time, error) {
+	engine := GetNamedEngine(name)
+
+	if engine == nil {
+		return nil, fmt.Errorf("Engine %v not initialized", name)
+	}
+
+	if err := engine.Sync2(new(migrationRecord)); err != nil {
+		return nil, err
+	}
+
+	var records []*migrationRecord
+
+	if err := engine.Find(&records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]time.Time)
+
+	for _, record := range records {
+		applied[record.Version] = record.AppliedAt
+	}
+
+	return applied, nil
+}
+
+func MigrationStatuses(name string) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gMigrations.Statuses(name, applied), nil
+}
+
+// Steps <= 0 applies every pending migration.
+func Migrate(ctx context.Context, name string, steps int) error {
+	applied, err := appliedMigrations(name)
+
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range gMigrations.Pending(name, applied, steps) {
+		if migration.Up == nil {
+			return fmt.Errorf("Migration %v has no Up", migration.Version)
+		}
+
+		if err = WithNamedTx(ctx, name, func(tx *Tx) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+
+			_, err := tx.Session().Insert(&migrationRecord{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			})
+
+			return err
+		}); err != nil {
+			logger.Error("Migrate %v %v-%v failed %v", name, migration.Version, migration.Name, err.Error())
+			return err
+		}
+
+		logger.Warning("Migrate %v %v-%v applied", name, migration.Version, migration.Name)
+	}
+
+	return nil
+}
+
+// Steps <= 0 rolls back the latest applied migration only.
+func Rollback(ctx context.Context, name string, steps int) error {
+	applied, err := appliedMigrations(name)
+
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range gMigrations.Applied(name, applied, steps) {
+		if migration.Down == nil {
+			return fmt.Errorf("Migration %v has no Down", migration.Version)
+		}
+
+		if err = WithNamedTx(ctx, name, func(tx *Tx) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+
+			_, err := tx.Session().ID(migration.Version).Delete(new(migrationRecord))
+
+			return err
+		}); err != nil {
+			logger.Error("Rollback %v %v-%v failed %v", name, migration.Version, migration.Name, err.Error())
+			return err
+		}
+
+		logger.Warning("Rollback %v %v-%v done", name, migration.Version, migration.Name)
+	}
+
+	return nil
+}
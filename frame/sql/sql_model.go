@@ -1,14 +1,19 @@
 package sql
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/derekyu332/goii/frame/base"
 	"github.com/derekyu332/goii/frame/cache"
+	"github.com/derekyu332/goii/frame/query"
+	"github.com/derekyu332/goii/helper/extend"
 	"github.com/derekyu332/goii/helper/logger"
 	_ "github.com/go-sql-driver/mysql"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 	"xorm.io/xorm"
 	"xorm.io/xorm/log"
@@ -16,7 +21,12 @@ import (
 )
 
 var (
-	gEngine *xorm.Engine
+	ErrStaleRecord    = errors.New("Stale record")
+	ErrRecordDeleted  = errors.New("Record deleted")
+	ErrRecordNotFound = errors.New("Record not found")
+	gEngines          = make(map[string]*xorm.EngineGroup)
+	gEngineLock       sync.RWMutex
+	gAliveDone        chan bool
 )
 
 type ISqlRecord interface {
@@ -31,39 +41,236 @@ func init() {
 
 }
 
-func InitEngine(driver string, dbUri string, maxIdelConns int) {
-	var err error
-	logger.Warning("Try Connect...")
-	gEngine, err = xorm.NewEngine(driver, dbUri)
+func InitEngine(driver string, dbUri string, maxIdelConns int) error {
+	return InitNamedEngine(base.DEFAULT_CONNECTION, driver, dbUri, maxIdelConns)
+}
+
+func InitNamedEngine(name string, driver string, dbUri string, maxIdelConns int) error {
+	return InitNamedEngineGroup(name, driver, dbUri, nil, nil, maxIdelConns)
+}
+
+func InitNamedEngineGroup(name string, driver string, master string, replicas []string, policy xorm.GroupPolicy,
+	maxIdelConns int) error {
+	logger.Warning("Try Connect %v...", name)
+
+	if policy == nil {
+		policy = xorm.RoundRobinPolicy()
+	}
+
+	group, err := xorm.NewEngineGroup(driver, append([]string{master}, replicas...), policy)
 
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	if err = group.Master().Ping(); err != nil {
+		group.Close()
+		return err
+	}
+
+	for i, slave := range group.Slaves() {
+		if err := slave.Ping(); err != nil {
+			logger.Warning("Replica %v-%v Ping failed %v", name, i, err.Error())
+			setHealthy(slave, false)
+		} else {
+			setHealthy(slave, true)
+		}
+	}
+
+	group.SetMaxIdleConns(maxIdelConns)
+	group.ShowSQL(false)
+	group.SetLogLevel(log.LOG_WARNING)
+
+	gEngineLock.Lock()
+	defer gEngineLock.Unlock()
+
+	if old, ok := gEngines[name]; ok {
+		forgetGroup(old)
+		old.Close()
+	}
+
+	gEngines[name] = group
+
+	if gAliveDone == nil {
+		gAliveDone = make(chan bool)
+		go keepAlive(10*time.Second, gAliveDone)
+	}
+
+	logger.Warning("Connect %v Success, %v replicas", name, len(replicas))
+
+	return nil
+}
+
+func GetNamedGroup(name string) *xorm.EngineGroup {
+	gEngineLock.RLock()
+	defer gEngineLock.RUnlock()
+
+	return gEngines[name]
+}
+
+func GetNamedEngine(name string) *xorm.Engine {
+	if group := GetNamedGroup(name); group != nil {
+		return group.Master()
+	}
+
+	return nil
+}
+
+func engineNames() []string {
+	gEngineLock.RLock()
+	defer gEngineLock.RUnlock()
+
+	names := make([]string, 0, len(gEngines))
+
+	for name := range gEngines {
+		names = append(names, name)
 	}
 
-	gEngine.SetMaxIdleConns(maxIdelConns)
-	gEngine.ShowSQL(false)
-	gEngine.SetLogLevel(log.LOG_WARNING)
-	go keepAlive(10 * time.Second)
-	logger.Warning("Connect Success")
+	sort.Strings(names)
+
+	return names
 }
 
-func keepAlive(d time.Duration) {
+func keepAlive(d time.Duration, done chan bool) {
 	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, name := range engineNames() {
+				group := GetNamedGroup(name)
 
-	for range ticker.C {
-		if err := gEngine.Ping(); err != nil {
-			logger.Warning("Engine Ping failed %v", err.Error())
+				if group == nil {
+					continue
+				}
+
+				if err := group.Master().Ping(); err != nil {
+					logger.Warning("Engine %v Ping failed %v", name, err.Error())
+				}
+
+				for i, slave := range group.Slaves() {
+					err := slave.Ping()
+
+					if err != nil {
+						logger.Warning("Replica %v-%v Ping failed %v", name, i, err.Error())
+					}
+
+					setHealthy(slave, err == nil)
+				}
+			}
+		case <-done:
+			return
 		}
 	}
 }
 
+func Ping(ctx context.Context) error {
+	names := engineNames()
+
+	if len(names) == 0 {
+		return errors.New("Engine not initialized")
+	}
+
+	for _, name := range names {
+		if engine := GetNamedEngine(name); engine != nil {
+			if err := engine.PingContext(ctx); err != nil {
+				return fmt.Errorf("%v: %v", name, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+func CloseEngine() error {
+	gEngineLock.Lock()
+	defer gEngineLock.Unlock()
+
+	if gAliveDone != nil {
+		close(gAliveDone)
+		gAliveDone = nil
+	}
+
+	var err error
+
+	for name, group := range gEngines {
+		forgetGroup(group)
+
+		if e := group.Close(); e != nil {
+			logger.Warning("Engine %v Close failed %v", name, e.Error())
+			err = e
+		}
+
+		delete(gEngines, name)
+		logger.Warning("Engine %v Closed", name)
+	}
+
+	return err
+}
+
 type SqlModel struct {
 	base.Model
-	oldAttr map[string]interface{}
+	UseMaster   bool
+	WithDeleted bool
+	oldAttr     map[string]interface{}
+	tx          *Tx
+	noCache     bool
 }
 
 func (this *SqlModel) GetEngine() *xorm.Engine {
-	return gEngine
+	return GetNamedEngine(base.ConnectionOf(this.Data))
+}
+
+func (this *SqlModel) WithContext(ctx context.Context) *SqlModel {
+	this.BindContext(ctx)
+
+	return this
+}
+
+// Sessions handed in by a Tx or a bulk call already carry their own context.
+func (this *SqlModel) bind(engine xorm.Interface, session *xorm.Session) *xorm.Session {
+	if _, ok := engine.(*xorm.Session); ok {
+		return session
+	}
+
+	return session.Context(this.Context())
+}
+
+func (this *SqlModel) readEngine() xorm.Interface {
+	if this.tx != nil {
+		return this.tx.session
+	}
+
+	group := GetNamedGroup(base.ConnectionOf(this.Data))
+
+	if group == nil {
+		return nil
+	}
+
+	if this.UseMaster || IsStickToMaster(this.RequestID) {
+		return group.Master()
+	}
+
+	return healthySlave(group)
+}
+
+func (this *SqlModel) writeEngine() xorm.Interface {
+	if this.tx != nil {
+		return this.tx.session
+	}
+
+	group := GetNamedGroup(base.ConnectionOf(this.Data))
+
+	if group == nil {
+		return nil
+	}
+
+	if len(group.Slaves()) > 0 {
+		StickToMaster(this.RequestID)
+	}
+
+	return group.Master()
 }
 
 func (this *SqlModel) LOG_RET_ERR(tableName string, tStart int64, op string, query interface{}, err error) error {
@@ -118,11 +325,13 @@ func (this *SqlModel) GetDirtyCols() []string {
 }
 
 func (this *SqlModel) Get(id interface{}) (base.IActiveRecord, error) {
-	if gEngine == nil {
+	engine := this.readEngine()
+
+	if engine == nil {
 		return nil, errors.New("Unexpected error")
 	}
 
-	if cache_record, ok := this.Data.(cache.ICacheRecord); ok && cache_record.ReadOnly() {
+	if cache_record, ok := this.Data.(cache.ICacheRecord); ok && cache_record.ReadOnly() && !this.noCache {
 		if record := cache.GetCacheRecord(fmt.Sprintf("%v@%v", id, this.Data.TableName())); record != nil {
 			this.Data = record
 			this.Exists = true
@@ -138,25 +347,30 @@ func (this *SqlModel) Get(id interface{}) (base.IActiveRecord, error) {
 		context = context_getter.GetContext()
 	}
 
-	has, err := gEngine.ID(id).Get(this.Data)
+	has, err := this.cached(func() string {
+		return cache.IdKey(this.Context(), this.Data, id)
+	}, func() (bool, error) {
+		return this.bind(engine, this.scope(engine.ID(id))).Get(this.Data)
+	})
 
 	if err == nil {
 		if has {
-			logger.Notice("[%v] Get %v success", this.RequestID, id)
-			logger.Info("[%v] %v", this.RequestID, this.Data)
+			logger.Notice("[%v] Get %v success", this.LogID(), id)
+			logger.Info("[%v] %v", this.LogID(), this.Data)
 			this.RefreshOldAttr()
 			this.Exists = true
+			err = base.RunAfterFind(this.Data)
 
 			if cache_record, ok := this.Data.(cache.ICacheRecord); ok && cache_record.ReadOnly() {
-				logger.Info("[%v] Set %v To Cache", this.RequestID, id)
+				logger.Info("[%v] Set %v To Cache", this.LogID(), id)
 				cache.SetCacheRecord(this.Data)
 			}
 		} else {
-			logger.Notice("[%v] Get %v no record", this.RequestID, id)
+			logger.Notice("[%v] Get %v no record", this.LogID(), id)
 			this.Exists = false
 		}
 	} else {
-		logger.Error("[%v] Get %v failed %v", this.RequestID, id, err)
+		logger.Error("[%v] Get %v failed %v", this.LogID(), id, err)
 	}
 
 	if context != nil {
@@ -169,37 +383,43 @@ func (this *SqlModel) Get(id interface{}) (base.IActiveRecord, error) {
 }
 
 func (this *SqlModel) Count(query interface{}, args ...interface{}) (int64, error) {
-	if gEngine == nil {
+	engine := this.readEngine()
+
+	if engine == nil {
 		return 0, errors.New("Unexpected error")
 	}
 
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	total, err := gEngine.Where(query, args...).Count(this.Data)
+	total, err := this.bind(engine, this.scope(engine.Where(query, args...))).Count(this.Data)
 
 	if err != nil {
-		logger.Error("[%v] Find %v failed %v", this.RequestID, query, err)
+		logger.Error("[%v] Find %v failed %v", this.LogID(), query, err)
 	}
 
 	return total, this.LOG_RET_ERR(this.Data.TableName(), req_start, "Count", query, err)
 }
 
 func (this *SqlModel) Sum(column string, query interface{}, args ...interface{}) (int64, error) {
-	if gEngine == nil {
+	engine := this.readEngine()
+
+	if engine == nil {
 		return 0, errors.New("Unexpected error")
 	}
 
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	sum, err := gEngine.Where(query, args...).SumInt(this.Data, column)
+	sum, err := this.bind(engine, this.scope(engine.Where(query, args...))).SumInt(this.Data, column)
 
 	if err != nil {
-		logger.Error("[%v] Find %v failed %v", this.RequestID, query, err)
+		logger.Error("[%v] Find %v failed %v", this.LogID(), query, err)
 	}
 
 	return sum, this.LOG_RET_ERR(this.Data.TableName(), req_start, "Sum", query, err)
 }
 
 func (this *SqlModel) Distinct(result interface{}, cols []string, query interface{}, args ...interface{}) error {
-	if gEngine == nil {
+	engine := this.readEngine()
+
+	if engine == nil {
 		return errors.New("Unexpected error")
 	}
 
@@ -207,15 +427,15 @@ func (this *SqlModel) Distinct(result interface{}, cols []string, query interfac
 	var err error
 
 	if query == "" {
-		err = gEngine.Table(this.Data).Distinct(cols...).Find(result)
+		err = this.bind(engine, this.scope(engine.Table(this.Data))).Distinct(cols...).Find(result)
 	} else {
-		err = gEngine.Table(this.Data).Where(query, args...).Distinct(cols...).Find(result)
+		err = this.bind(engine, this.scope(engine.Table(this.Data).Where(query, args...))).Distinct(cols...).Find(result)
 	}
 
 	if err != nil {
-		logger.Error("[%v] Distinct %v failed %v", this.RequestID, cols, err)
+		logger.Error("[%v] Distinct %v failed %v", this.LogID(), cols, err)
 	} else {
-		logger.Notice("[%v] Distinct %v Success.", this.RequestID, cols)
+		logger.Notice("[%v] Distinct %v Success.", this.LogID(), cols)
 	}
 
 	return this.LOG_RET_ERR(this.Data.TableName(), req_start, "Distinct", cols, err)
@@ -229,38 +449,49 @@ type FindAllSelector struct {
 	Cols  []string
 	Asc   string
 	Desc  string
+	Query *query.Query
 }
 
-func (this *SqlModel) FindAll(result interface{}, selector FindAllSelector) error {
-	if selector.Asc == "" && selector.Desc == "" {
-		return errors.New("selector.Asc == ''")
+func (this *SqlModel) checkSelector(selector FindAllSelector) error {
+	if selector.Query != nil {
+		if err := selector.Query.Validate(this.Data, "sql"); err != nil {
+			logger.Warning("[%v] Query %v invalid %v", this.LogID(), selector.Query, err.Error())
+			return err
+		}
+
+		if len(selector.Query.Sorts()) > 0 {
+			return nil
+		}
 	}
 
-	if gEngine == nil {
-		return errors.New("Unexpected error")
+	if selector.Asc == "" && selector.Desc == "" {
+		return errors.New("selector.Asc == ''")
 	}
 
-	req_start := time.Now().UnixNano() / int64(time.Millisecond)
-	var session *xorm.Session
+	return nil
+}
 
-	if selector.Asc != "" {
-		ascs := strings.Split(selector.Asc, ",")
-		session = gEngine.Asc(ascs...)
+func selectorCond(selector FindAllSelector) interface{} {
+	if selector.Query != nil {
+		return selector.Query
 	}
 
-	if selector.Desc != "" {
-		descs := strings.Split(selector.Desc, ",")
-		session = gEngine.Desc(descs...)
-	}
+	return selector.Cond
+}
 
-	if selector.Table != "" {
-		session = session.Table(selector.Table)
+func (this *SqlModel) FindAll(result interface{}, selector FindAllSelector) error {
+	if err := this.checkSelector(selector); err != nil {
+		return err
 	}
 
-	if selector.Cols != nil {
-		session = session.Cols(selector.Cols...)
+	engine := this.readEngine()
+
+	if engine == nil {
+		return errors.New("Unexpected error")
 	}
 
+	req_start := time.Now().UnixNano() / int64(time.Millisecond)
+	session := this.bind(engine, this.scope(selectorSession(engine, selector)))
 	var err error
 
 	if selector.Page < 0 || selector.Limit <= 0 {
@@ -279,17 +510,81 @@ func (this *SqlModel) FindAll(result interface{}, selector FindAllSelector) erro
 		}
 	}
 
+	if err == nil {
+		err = base.RunAfterFindAll(result)
+	}
+
 	if err != nil {
-		logger.Error("[%v] FindAll %v failed %v", this.RequestID, selector.Cond, err)
+		logger.Error("[%v] FindAll %v failed %v", this.LogID(), selectorCond(selector), err)
 	} else {
-		logger.Notice("[%v] FindAll %v Success.", this.RequestID, selector.Cond)
+		logger.Notice("[%v] FindAll %v Success.", this.LogID(), selectorCond(selector))
 	}
 
-	return this.LOG_RET_ERR(this.Data.TableName(), req_start, "FindAll", selector.Cond, err)
+	return this.LOG_RET_ERR(this.Data.TableName(), req_start, "FindAll", selectorCond(selector), err)
+}
+
+func selectorSession(engine xorm.Interface, selector FindAllSelector) *xorm.Session {
+	if selector.Query != nil {
+		session := engine.Where(selector.Query.Builder())
+
+		for _, sort := range selector.Query.Sorts() {
+			if strings.HasPrefix(sort, "-") {
+				session = session.Desc(sort[1:])
+			} else {
+				session = session.Asc(sort)
+			}
+		}
+
+		if len(selector.Query.Sorts()) == 0 {
+			if selector.Asc != "" {
+				session = session.Asc(strings.Split(selector.Asc, ",")...)
+			}
+
+			if selector.Desc != "" {
+				session = session.Desc(strings.Split(selector.Desc, ",")...)
+			}
+		}
+
+		if selector.Table != "" {
+			session = session.Table(selector.Table)
+		}
+
+		if len(selector.Query.Fields()) > 0 {
+			session = session.Cols(selector.Query.Fields()...)
+		} else if selector.Cols != nil {
+			session = session.Cols(selector.Cols...)
+		}
+
+		return session
+	}
+
+	var session *xorm.Session
+
+	if selector.Asc != "" {
+		ascs := strings.Split(selector.Asc, ",")
+		session = engine.Asc(ascs...)
+	}
+
+	if selector.Desc != "" {
+		descs := strings.Split(selector.Desc, ",")
+		session = engine.Desc(descs...)
+	}
+
+	if selector.Table != "" {
+		session = session.Table(selector.Table)
+	}
+
+	if selector.Cols != nil {
+		session = session.Cols(selector.Cols...)
+	}
+
+	return session
 }
 
 func (this *SqlModel) FindOne(query interface{}, args ...interface{}) (base.IActiveRecord, error) {
-	if gEngine == nil {
+	engine := this.readEngine()
+
+	if engine == nil {
 		return nil, errors.New("Unexpected error")
 	}
 
@@ -301,20 +596,25 @@ func (this *SqlModel) FindOne(query interface{}, args ...interface{}) (base.IAct
 		context = context_getter.GetContext()
 	}
 
-	has, err := gEngine.Where(query, args...).Get(this.Data)
+	has, err := this.cached(func() string {
+		return cache.QueryKey(this.Context(), this.Data, query, args)
+	}, func() (bool, error) {
+		return this.bind(engine, this.scope(engine.Where(query, args...))).Get(this.Data)
+	})
 
 	if err == nil {
 		if has {
-			logger.Notice("[%v] Find %v success", this.RequestID, query)
-			logger.Info("[%v] %v", this.RequestID, this.Data)
+			logger.Notice("[%v] Find %v success", this.LogID(), query)
+			logger.Info("[%v] %v", this.LogID(), this.Data)
 			this.RefreshOldAttr()
 			this.Exists = true
+			err = base.RunAfterFind(this.Data)
 		} else {
-			logger.Notice("[%v] Find %v no record", this.RequestID, query)
+			logger.Notice("[%v] Find %v no record", this.LogID(), query)
 			this.Exists = false
 		}
 	} else {
-		logger.Error("[%v] Find %v failed %v", this.RequestID, query, err)
+		logger.Error("[%v] Find %v failed %v", this.LogID(), query, err)
 	}
 
 	if context != nil {
@@ -327,7 +627,28 @@ func (this *SqlModel) FindOne(query interface{}, args ...interface{}) (base.IAct
 }
 
 func (this *SqlModel) Delete() error {
-	if gEngine == nil || !this.Exists {
+	if !this.Exists {
+		return errors.New("Unexpected error")
+	}
+
+	if err := base.RunBeforeDelete(this.Data); err != nil {
+		logger.Warning("[%v] BeforeDelete %v failed %v", this.LogID(), this.Data.GetId(), err.Error())
+		return err
+	}
+
+	if err := this.delete(); err != nil {
+		return err
+	}
+
+	this.refreshCache(true)
+
+	return base.RunAfterDelete(this.Data)
+}
+
+func (this *SqlModel) delete() error {
+	engine := this.writeEngine()
+
+	if engine == nil || !this.Exists {
 		return errors.New("Unexpected error")
 	}
 
@@ -351,15 +672,25 @@ func (this *SqlModel) Delete() error {
 		}
 
 		var affected int64
-		affected, err = gEngine.ID(pk).Delete(this.Data)
+
+		// Records deleted before are not found, rather than deleted and audited again.
+		if deleter, ok := this.Data.(TableSoftDeleter); ok {
+			affected, err = this.bind(engine, engine.Table(this.Data)).ID(pk).And(deleter.SoftDeleteAttr() + " IS NULL").
+				Update(map[string]interface{}{
+					deleter.SoftDeleteAttr(): time.Now(),
+				})
+		} else {
+			affected, err = this.bind(engine, engine.ID(pk)).Delete(this.Data)
+		}
 
 		if err != nil {
-			logger.Warning("[%v] Delete %v failed %v", this.RequestID, pk, err.Error())
+			logger.Warning("[%v] Delete %v failed %v", this.LogID(), pk, err.Error())
 		} else if affected == 0 {
-			logger.Warning("[%v] Delete %v not affected", this.RequestID, pk)
-			return errors.New("Unexpected error")
+			logger.Warning("[%v] Delete %v not affected", this.LogID(), pk)
+			err = ErrRecordNotFound
 		} else {
-			logger.Notice("[%v] Delete %v success", this.RequestID, pk)
+			logger.Notice("[%v] Delete %v success", this.LogID(), pk)
+			this.writeAudit(engine, this.auditLog(AUDIT_DELETE, auditCopy(this.oldAttr), nil))
 		}
 	}
 
@@ -367,69 +698,210 @@ func (this *SqlModel) Delete() error {
 }
 
 func (this *SqlModel) Save() error {
-	if gEngine == nil {
+	insert := !this.Exists
+
+	if err := base.RunBeforeValidate(this.Data); err != nil {
+		logger.Warning("[%v] BeforeValidate %v failed %v", this.LogID(), this.Data.GetId(), err.Error())
+		return err
+	}
+
+	if err := this.Validate(); err != nil {
+		logger.Warning("[%v] Validate %v failed %v", this.LogID(), this.Data.GetId(), err.Error())
+		return err
+	}
+
+	if err := base.RunBeforeSave(this.Data, insert); err != nil {
+		logger.Warning("[%v] BeforeSave %v failed %v", this.LogID(), this.Data.GetId(), err.Error())
+		return err
+	}
+
+	if err := this.save(); err != nil {
+		return err
+	}
+
+	this.refreshCache(false)
+
+	return base.RunAfterSave(this.Data, insert)
+}
+
+func (this *SqlModel) save() error {
+	engine := this.writeEngine()
+
+	if engine == nil {
 		return errors.New("Unexpected error")
 	}
 
 	req_start := time.Now().UnixNano() / int64(time.Millisecond)
 	var err error
-	var affected int64
 
 	if this.Exists {
 		dirty_cols := this.GetDirtyCols()
 
 		if len(dirty_cols) <= 0 {
-			logger.Notice("[%v] Update %v No Change", this.RequestID, this.Data.GetId())
+			logger.Notice("[%v] Update %v No Change", this.LogID(), this.Data.GetId())
 			return nil
 		}
 
-		record, ok := this.Data.(ISqlRecord)
-
-		if !ok {
-			logger.Warning("[%v] ISqlRecord not implemented", this.RequestID)
-			return errors.New("Unexpected error")
+		if err = this.update(engine, dirty_cols); err == nil {
+			before, after := auditDiff(this.oldAttr, base.StructToMap(this.Data, "sql"), dirty_cols)
+			this.writeAudit(engine, this.auditLog(AUDIT_UPDATE, before, after))
+			this.RefreshOldAttr()
 		}
+	} else {
+		_, err = this.bind(engine, engine.Table(this.Data)).Insert(this.Data)
 
-		if cache_record, ok := this.Data.(cache.ICacheRecord); ok && cache_record.ReadOnly() {
-			logger.Warning("[%v] ICacheRecord readonly", this.RequestID)
-			return errors.New("Unexpected error")
+		if err != nil {
+			logger.Warning("[%v] Insert %v failed %v", this.LogID(), this.Data, err.Error())
+		} else {
+			logger.Notice("[%v] Insert %v success", this.LogID(), this.Data)
+			this.RefreshOldAttr()
+			this.Exists = true
+			this.writeAudit(engine, this.auditLog(AUDIT_INSERT, nil, auditCopy(this.oldAttr)))
 		}
+	}
+
+	return this.LOG_RET_ERR(this.Data.TableName(), req_start, "Save", this.Data.GetId(), err)
+}
 
-		/*if locker, ok := this.Data.(TableLocker); ok {
-			lock_name := locker.OptimisticLock()
-			dirty_attr[lock_name] = this.oldAttr[lock_name]
-		}*/
+// Reads inside a Tx or including soft deleted rows bypass the shared cache.
+func (this *SqlModel) cached(key func() string, load func() (bool, error)) (bool, error) {
+	if !cache.Cacheable(this.Data) || this.tx != nil || this.WithDeleted || this.noCache {
+		return load()
+	}
 
-		var pk interface{}
+	return cache.Load(this.Context(), key(), this.Data, load)
+}
 
-		if len(record.PrimaryKey()) <= 1 {
-			pk = this.Data.GetId()
-		} else {
-			pk = record.PrimaryKey()
+// Writes inside a Tx are not committed yet, so they only invalidate.
+func (this *SqlModel) refreshCache(deleted bool) {
+	if !cache.Cacheable(this.Data) {
+		return
+	}
+
+	if deleted || this.tx != nil {
+		cache.Invalidate(this.Context(), this.Data, this.Data.GetId())
+	} else {
+		cache.Store(this.Context(), this.Data)
+	}
+}
+
+func (this *SqlModel) update(engine xorm.Interface, dirty_cols []string) error {
+	record, ok := this.Data.(ISqlRecord)
+
+	if !ok {
+		logger.Warning("[%v] ISqlRecord not implemented", this.LogID())
+		return errors.New("Unexpected error")
+	}
+
+	if cache_record, ok := this.Data.(cache.ICacheRecord); ok && cache_record.ReadOnly() {
+		logger.Warning("[%v] ICacheRecord readonly", this.LogID())
+		return errors.New("Unexpected error")
+	}
+
+	var pk interface{}
+
+	if len(record.PrimaryKey()) <= 1 {
+		pk = this.Data.GetId()
+	} else {
+		pk = record.PrimaryKey()
+	}
+
+	var affected int64
+	var err error
+
+	if locker, ok := this.Data.(TableLocker); ok {
+		lock_name := locker.OptimisticLock()
+		lock_seq, _ := extend.InterfaceToInt64(this.oldAttr[lock_name])
+		cols := []string{lock_name}
+
+		for _, col := range dirty_cols {
+			if col != lock_name {
+				cols = append(cols, col)
+			}
 		}
 
-		affected, err = gEngine.ID(pk).Cols(dirty_cols...).Update(this.Data)
+		setLockValue(this.Data, lock_name, lock_seq+1)
+		affected, err = this.bind(engine, engine.ID(pk)).Where(lock_name+" = ?", lock_seq).Cols(cols...).Update(this.Data)
 
-		if err != nil {
-			logger.Warning("[%v] Update %v failed %v", this.RequestID, pk, err.Error())
-		} else if affected == 0 {
-			logger.Warning("[%v] Update %v not affected", this.RequestID, pk)
-			return errors.New("Unexpected error")
-		} else {
-			logger.Notice("[%v] Update %v success", this.RequestID, pk)
-			this.RefreshOldAttr()
+		if err == nil && affected == 0 {
+			setLockValue(this.Data, lock_name, lock_seq)
+			logger.Warning("[%v] Update %v stale %v = %v", this.LogID(), pk, lock_name, lock_seq)
+
+			return ErrStaleRecord
+		} else if err != nil {
+			setLockValue(this.Data, lock_name, lock_seq)
 		}
 	} else {
-		affected, err = gEngine.Insert(this.Data)
+		affected, err = this.bind(engine, engine.ID(pk)).Cols(dirty_cols...).Update(this.Data)
+	}
+
+	if err != nil {
+		logger.Warning("[%v] Update %v failed %v", this.LogID(), pk, err.Error())
+		return err
+	} else if affected == 0 {
+		logger.Warning("[%v] Update %v not affected", this.LogID(), pk)
+		return errors.New("Unexpected error")
+	}
+
+	logger.Notice("[%v] Update %v success", this.LogID(), pk)
+
+	return nil
+}
+
+func (this *SqlModel) SaveWithRetry(times int, mutate func(record base.IActiveRecord) error) error {
+	for i := 0; ; i++ {
+		if err := mutate(this.Data); err != nil {
+			return err
+		}
+
+		err := this.Save()
+
+		if err != ErrStaleRecord || i >= times {
+			return err
+		}
+
+		logger.Notice("[%v] Retry %v after stale record", this.LogID(), this.Data.GetId())
+		// The cached copy is what went stale, reload it from the master.
+		useMaster := this.UseMaster
+		this.UseMaster, this.noCache = true, true
+		_, err = this.Get(this.Data.GetId())
+		this.UseMaster, this.noCache = useMaster, false
 
 		if err != nil {
-			logger.Warning("[%v] Insert %v failed %v", this.RequestID, this.Data, err.Error())
-		} else {
-			logger.Notice("[%v] Insert %v success", this.RequestID, this.Data)
-			this.RefreshOldAttr()
-			this.Exists = true
+			return err
+		} else if !this.Exists {
+			return ErrStaleRecord
 		}
 	}
+}
 
-	return this.LOG_RET_ERR(this.Data.TableName(), req_start, "Save", this.Data.GetId(), err)
+func setLockValue(data interface{}, lockName string, value int64) {
+	v := reflect.ValueOf(data)
+
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("sql"), ",")[0] != lockName {
+			continue
+		}
+
+		field := v.Field(i)
+
+		switch field.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			field.SetInt(value)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			field.SetUint(uint64(value))
+		}
+
+		return
+	}
 }
@@ -0,0 +1,161 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/derekyu332/goii/frame/base"
+	"github.com/derekyu332/goii/helper/logger"
+	"time"
+	"xorm.io/xorm"
+)
+
+type txKey string
+
+type Tx struct {
+	RequestID int64
+	name      string
+	session   *xorm.Session
+	ctx       context.Context
+	savepoint int
+	bound     []*SqlModel
+}
+
+func TxFromContext(ctx context.Context, name string) *Tx {
+	if ctx == nil {
+		return nil
+	}
+
+	tx, _ := ctx.Value(txKey(name)).(*Tx)
+
+	return tx
+}
+
+func WithTx(ctx context.Context, fn func(tx *Tx) error) error {
+	return WithNamedTx(ctx, base.DEFAULT_CONNECTION, fn)
+}
+
+func WithNamedTx(ctx context.Context, name string, fn func(tx *Tx) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if parent := TxFromContext(ctx, name); parent != nil {
+		return parent.nested(fn)
+	}
+
+	engine := GetNamedEngine(name)
+
+	if engine == nil {
+		return errors.New("Unexpected error")
+	}
+
+	tx := &Tx{name: name, session: engine.NewSession().Context(ctx)}
+	tx.RequestID, _ = ctx.Value(base.KEY_REQUEST_ID).(int64)
+	tx.ctx = context.WithValue(ctx, txKey(name), tx)
+	defer tx.session.Close()
+	defer tx.unbind()
+
+	if err := tx.exec("Begin", "", tx.session.Begin); err != nil {
+		return err
+	}
+
+	return tx.call(fn, func() error {
+		err := tx.exec("Commit", "", tx.session.Commit)
+
+		if err == nil {
+			if group := GetNamedGroup(name); group != nil && len(group.Slaves()) > 0 {
+				StickToMaster(tx.RequestID)
+			}
+		}
+
+		return err
+	}, func() error {
+		return tx.exec("Rollback", "", tx.session.Rollback)
+	})
+}
+
+func (this *Tx) Context() context.Context {
+	return this.ctx
+}
+
+func (this *Tx) Session() *xorm.Session {
+	return this.session
+}
+
+func (this *Tx) Bind(models ...*SqlModel) {
+	for _, model := range models {
+		model.tx = this
+
+		if model.RequestID == 0 {
+			model.RequestID = this.RequestID
+		}
+
+		this.bound = append(this.bound, model)
+	}
+}
+
+func (this *Tx) unbind() {
+	for _, model := range this.bound {
+		if model.tx == this {
+			model.tx = nil
+		}
+	}
+
+	this.bound = nil
+}
+
+func (this *Tx) nested(fn func(tx *Tx) error) error {
+	this.savepoint++
+	name := fmt.Sprintf("sp_%v", this.savepoint)
+
+	if err := this.exec("Savepoint", name, func() error {
+		_, err := this.session.Exec("SAVEPOINT " + name)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return this.call(fn, func() error {
+		return this.exec("Release", name, func() error {
+			_, err := this.session.Exec("RELEASE SAVEPOINT " + name)
+			return err
+		})
+	}, func() error {
+		return this.exec("RollbackTo", name, func() error {
+			_, err := this.session.Exec("ROLLBACK TO SAVEPOINT " + name)
+			return err
+		})
+	})
+}
+
+func (this *Tx) call(fn func(tx *Tx) error, commit func() error, rollback func() error) error {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("[%v] Transaction %v panic %v", this.RequestID, this.name, r)
+			rollback()
+			panic(r)
+		}
+	}()
+
+	if err := fn(this); err != nil {
+		logger.Warning("[%v] Transaction %v failed %v", this.RequestID, this.name, err.Error())
+
+		if e := rollback(); e != nil {
+			logger.Error("[%v] Transaction %v rollback failed %v", this.RequestID, this.name, e.Error())
+		}
+
+		return err
+	}
+
+	return commit()
+}
+
+func (this *Tx) exec(op string, savepoint string, fn func() error) error {
+	req_start := time.Now().UnixNano() / int64(time.Millisecond)
+	err := fn()
+	model := &SqlModel{}
+	model.RequestID = this.RequestID
+
+	return model.LOG_RET_ERR(this.name, req_start, "Tx"+op, savepoint, err)
+}
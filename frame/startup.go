@@ -0,0 +1,112 @@
+package frame
+
+import (
+	"fmt"
+	"github.com/derekyu332/goii/helper/extend"
+	"github.com/derekyu332/goii/helper/logger"
+	"time"
+)
+
+const (
+	SUBSYSTEM_CONFIG    = "config"
+	SUBSYSTEM_MODULE    = "module"
+	SUBSYSTEM_SQL       = "sql"
+	SUBSYSTEM_MONGO     = "mongo"
+	SUBSYSTEM_I18N      = "i18n"
+	SUBSYSTEM_REDIS     = "redis"
+	SUBSYSTEM_RABBIT    = "rabbit"
+	SUBSYSTEM_WORKER    = "worker"
+	SUBSYSTEM_KAFKA     = "kafka"
+	SUBSYSTEM_ETHER     = "ether"
+	SUBSYSTEM_COMPONENT = "component"
+)
+
+const (
+	DEFAULT_RETRY_DELAY     = 1 * time.Second
+	DEFAULT_RETRY_MAX_DELAY = 30 * time.Second
+)
+
+type RetryConfig struct {
+	Attempts int
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+type StartupError struct {
+	Subsystem string
+	Err       error
+}
+
+func (this *StartupError) Error() string {
+	return fmt.Sprintf("startup %v failed: %v", this.Subsystem, this.Err)
+}
+
+func (this *StartupError) Unwrap() error {
+	return this.Err
+}
+
+func (this *App) Degraded() []string {
+	return this.degraded
+}
+
+func (this *App) IsDegraded(subsystem string) bool {
+	return extend.InStringArray(subsystem, this.degraded) >= 0
+}
+
+func startupAttempt(init func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic %v", r)
+		}
+	}()
+
+	return init()
+}
+
+func (this *App) startupStep(subsystem string, retry bool, init func() error) error {
+	attempts := 1
+	delay := DEFAULT_RETRY_DELAY
+	maxDelay := DEFAULT_RETRY_MAX_DELAY
+
+	if retry && this.RetryInit != nil {
+		if this.RetryInit.Attempts > 1 {
+			attempts = this.RetryInit.Attempts
+		}
+
+		if this.RetryInit.Delay > 0 {
+			delay = this.RetryInit.Delay
+		}
+
+		if this.RetryInit.MaxDelay > 0 {
+			maxDelay = this.RetryInit.MaxDelay
+		}
+	}
+
+	var err error
+
+	for i := 1; i <= attempts; i++ {
+		if err = startupAttempt(init); err == nil {
+			return nil
+		}
+
+		logger.Warning("Init %v failed %v (%v/%v)", subsystem, err.Error(), i, attempts)
+
+		if i < attempts {
+			time.Sleep(delay)
+
+			if delay *= 2; delay > maxDelay {
+				delay = maxDelay
+			}
+		}
+	}
+
+	// The module is never optional, the rest of the startup depends on it.
+	if subsystem != SUBSYSTEM_MODULE && extend.InStringArray(subsystem, this.OptionalSubsystems) >= 0 {
+		logger.Error("Init %v failed, start degraded", subsystem)
+		this.degraded = append(this.degraded, subsystem)
+
+		return nil
+	}
+
+	return &StartupError{Subsystem: subsystem, Err: err}
+}
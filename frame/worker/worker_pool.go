@@ -31,7 +31,8 @@ func (c *WorketContext) Log(job *work.Job, next work.NextMiddlewareFunc) error {
 	return next()
 }
 
-func InitPool(url string, passowrd string, concurrency int, namespace string) {
+// Fails when redis can not be reached, the pool is only kept on success.
+func InitPool(url string, passowrd string, concurrency int, namespace string) error {
 	redisPool := &redis.Pool{
 		Dial: func() (redis.Conn, error) {
 			c, err := redis.DialURL(url, redis.DialConnectTimeout(REDIS_CONNECT_TIME_OUT*time.Second),
@@ -54,10 +55,22 @@ func InitPool(url string, passowrd string, concurrency int, namespace string) {
 		MaxIdle:     REDIS_MAX_IDLE,
 		Wait: true,
 	}
+	session := redisPool.Get()
+	_, err := session.Do("PING")
+	session.Close()
+
+	if err != nil {
+		logger.Error("WorkerPool Init failed %v", err)
+		redisPool.Close()
+		return err
+	}
+
 	gWorkerPool = work.NewWorkerPoolWithOptions(WorketContext{}, uint(concurrency), namespace,
 		redisPool, work.WorkerPoolOptions{SleepBackoffs: []int64{0, 10, 100, 200, 400, 500, 1000}})
 	gWorkerPool.Middleware((*WorketContext).Log)
 	logger.Warning("WorkerPool Init Success")
+
+	return nil
 }
 
 func Run(serviceName string, module base.IModule) {